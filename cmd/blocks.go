@@ -17,14 +17,32 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/common/model"
+	promTsdb "github.com/prometheus/tsdb"
 	"github.com/spf13/cobra"
 )
 
+var tree bool
+var check bool
+
+// blockFiles is the on-disk layout of a block.
+type blockFiles struct {
+	Chunks     int64
+	Segments   int
+	Index      int64
+	Tombstones int64
+}
+
 // blocksCmd represents the blocks command
 var blocksCmd = &cobra.Command{
 	Use:   "blocks",
@@ -32,13 +50,35 @@ var blocksCmd = &cobra.Command{
 	Long: `
 Lists all the blocks under the Prometheus TSDB path. With metadata
 
+LEVEL, SOURCES and PARENTS come from the compaction section of meta.json. CHUNKS, INDEX and TOMBSTONES are the sizes
+of the files on disk, SEGMENTS is the number of chunk segment files.
+
+With --tree it shows the compaction lineage instead: the parents each block was merged from. It reads the meta.json files
+without opening the TSDB, which would delete the parents still on disk, so those are expanded too.
+
+With --check it reports the overlapping blocks, the gaps between blocks and the blocks that don't fit in an aligned
+block range of Prometheus (2h, 6h, 18h, ...). Overlaps mean a vertical compaction or a bad backfill, gaps mean that
+Prometheus was down. It exits with 1 if it finds any.
+
 Example usage:
 
   ➜  tsdbinfo blocks --storage.tsdb.path.copy=/my/prometheus/path/data
-  ID                            FROM                         UNTIL                        STATS
-  01CZWK46GK8BVHQCRNNS763NS3    2018-12-22T13:00:00+01:00    2018-12-29T07:00:00+01:00    {"numSamples":3167899784,"numSeries":3070548,"numChunks":29336192,"numBytes":4419004512}
-  01D1EFWJ44G9WGN7AQ9398G2W2    2019-01-11T01:00:00+01:00    2019-01-11T19:00:00+01:00    {"numBytes":8634}
-  01D1EFWJRQ35VYNT2M4YYEJV3R    2019-01-16T07:00:00+01:00    2019-01-17T01:00:00+01:00    {"numBytes":8634}
+  ID                            FROM                         UNTIL                        DURATION    LEVEL    SOURCES    PARENTS    CHUNKS     INDEX      TOMBSTONES    SEGMENTS    DELETIONS    STATS
+  01CZWK46GK8BVHQCRNNS763NS3    2018-12-22T13:00:00+01:00    2018-12-29T07:00:00+01:00    6d18h       5        81         3          3.9 GiB    253.4 MiB  8 B           9           no           {"numSamples":3167899784,"numSeries":3070548,"numChunks":29336192,"numBytes":4419004512}
+  01D1EFWJ44G9WGN7AQ9398G2W2    2019-01-11T01:00:00+01:00    2019-01-11T19:00:00+01:00    18h         3        9          3          8.2 KiB    311 B      8 B           1           no           {"numBytes":8634}
+  01D1EFWJRQ35VYNT2M4YYEJV3R    2019-01-16T07:00:00+01:00    2019-01-17T01:00:00+01:00    18h         3        9          3          8.2 KiB    311 B      44 B          1           yes (2)      {"numBytes":8634}
+
+  ➜  tsdbinfo blocks --storage.tsdb.path.copy=/my/prometheus/path/data --tree
+  01D1EFWJ44G9WGN7AQ9398G2W2    2019-01-11T01:00:00+01:00    2019-01-11T19:00:00+01:00    level 3, 9 sources
+  ├── 01D1CQ0F5FG6P7DQ0WKEB4KZ52    2019-01-11T01:00:00+01:00    2019-01-11T07:00:00+01:00    compacted
+  ├── 01D1DBK3NXTKHMV6ZX8BSY66AD    2019-01-11T07:00:00+01:00    2019-01-11T13:00:00+01:00    compacted
+  └── 01D1DZ5RRWCNB7M8A3T8SRKHVZ    2019-01-11T13:00:00+01:00    2019-01-11T19:00:00+01:00    compacted
+
+  ➜  tsdbinfo blocks --storage.tsdb.path.copy=/my/prometheus/path/data --check
+  ISSUE      FROM                         UNTIL                        DURATION    BLOCKS
+  gap        2018-12-29T07:00:00+01:00    2019-01-11T01:00:00+01:00    12d18h      01CZWK46GK8BVHQCRNNS763NS3, 01D1EFWJ44G9WGN7AQ9398G2W2
+  overlap    2019-01-11T13:00:00+01:00    2019-01-11T19:00:00+01:00    6h          01D1EFWJ44G9WGN7AQ9398G2W2, 01D1F0R2XBMJ4RZ4MWA0M3M9K2
+  range      2019-01-11T13:00:00+01:00    2019-01-11T21:00:00+01:00    8h          01D1F0R2XBMJ4RZ4MWA0M3M9K2
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -48,6 +88,44 @@ Example usage:
 			os.Exit(1)
 		}
 
+		if tree {
+			// opening the TSDB deletes the parents that are still on disk, so
+			// the tree is drawn from the meta.json files
+			metas, err := readBlockMetas(storagePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading the blocks failed: %s", err)
+				os.Exit(1)
+			}
+			sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+			onDisk := make(map[string]promTsdb.BlockMeta)
+			for _, meta := range metas {
+				onDisk[meta.ULID.String()] = meta
+			}
+			merged := make(map[string]bool)
+			for _, meta := range metas {
+				for _, parent := range meta.Compaction.Parents {
+					merged[parent.ULID.String()] = true
+				}
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+			for _, meta := range metas {
+				if merged[meta.ULID.String()] {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%v\t%v\tlevel %d, %d sources\n",
+					meta.ULID,
+					time.Unix(meta.MinTime/1000, 0).Format(time.RFC3339),
+					time.Unix(meta.MaxTime/1000, 0).Format(time.RFC3339),
+					meta.Compaction.Level,
+					len(meta.Compaction.Sources),
+				)
+				printParents(w, meta.Compaction.Parents, onDisk, "")
+			}
+			w.Flush()
+			return
+		}
+
 		db, err := common.Open(storagePath, noPromLogs)
 		if err != nil {
 			fmt.Printf("opening storage failed: %s", err)
@@ -55,15 +133,54 @@ Example usage:
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "ID\tFROM\tUNTIL\tSTATS")
+
+		if check {
+			issues := checkBlocks(db.Blocks())
+			if len(issues) == 0 {
+				fmt.Println("No overlaps, gaps or range mismatches.")
+				return
+			}
+			fmt.Fprintln(w, "ISSUE\tFROM\tUNTIL\tDURATION\tBLOCKS")
+			for _, issue := range issues {
+				fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%s\n",
+					issue.Kind,
+					time.Unix(issue.MinTime/1000, 0).Format(time.RFC3339),
+					time.Unix(issue.MaxTime/1000, 0).Format(time.RFC3339),
+					model.Duration(time.Duration(issue.MaxTime-issue.MinTime)*time.Millisecond),
+					strings.Join(issue.Blocks, ", "),
+				)
+			}
+			w.Flush()
+			os.Exit(1)
+		}
+
+		fmt.Fprintln(w, "ID\tFROM\tUNTIL\tDURATION\tLEVEL\tSOURCES\tPARENTS\tCHUNKS\tINDEX\tTOMBSTONES\tSEGMENTS\tDELETIONS\tSTATS")
 
 		for _, block := range db.Blocks() {
 			meta := block.Meta()
 			stats, _ := json.Marshal(meta.Stats)
-			fmt.Fprintf(w, "%s\t%v\t%v\t%s\n",
+			files, err := readBlockFiles(block.Dir())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading the files of block %s failed: %s", meta.ULID, err)
+				os.Exit(1)
+			}
+			deletions := "no"
+			if meta.Stats.NumTombstones > 0 {
+				deletions = fmt.Sprintf("yes (%d)", meta.Stats.NumTombstones)
+			}
+			fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
 				meta.ULID,
 				time.Unix(meta.MinTime/1000, 0).Format(time.RFC3339),
 				time.Unix(meta.MaxTime/1000, 0).Format(time.RFC3339),
+				model.Duration(time.Duration(meta.MaxTime-meta.MinTime)*time.Millisecond),
+				meta.Compaction.Level,
+				len(meta.Compaction.Sources),
+				len(meta.Compaction.Parents),
+				formatBytes(int(files.Chunks)),
+				formatBytes(int(files.Index)),
+				formatBytes(int(files.Tombstones)),
+				files.Segments,
+				deletions,
 				string(stats),
 			)
 		}
@@ -72,6 +189,147 @@ Example usage:
 	},
 }
 
+// blockIssue is an overlap, a gap or a range mismatch of the blocks.
+type blockIssue struct {
+	Kind    string
+	MinTime int64
+	MaxTime int64
+	Blocks  []string
+}
+
+// checkBlocks returns the overlapping ranges, the gaps between consecutive
+// blocks and the blocks that don't fit in an aligned block range, ordered by
+// time.
+func checkBlocks(blocks []*promTsdb.Block) []blockIssue {
+	var metas []promTsdb.BlockMeta
+	for _, block := range blocks {
+		metas = append(metas, block.Meta())
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+
+	var issues []blockIssue
+	for r, overlapping := range promTsdb.OverlappingBlocks(metas) {
+		issue := blockIssue{Kind: "overlap", MinTime: r.Min, MaxTime: r.Max}
+		for _, meta := range overlapping {
+			issue.Blocks = append(issue.Blocks, meta.ULID.String())
+		}
+		issues = append(issues, issue)
+	}
+
+	// the block that ends last so far, a gap starts where it ends
+	var last promTsdb.BlockMeta
+	for i, meta := range metas {
+		if i > 0 && meta.MinTime > last.MaxTime {
+			issues = append(issues, blockIssue{
+				Kind:    "gap",
+				MinTime: last.MaxTime,
+				MaxTime: meta.MinTime,
+				Blocks:  []string{last.ULID.String(), meta.ULID.String()},
+			})
+		}
+		if i == 0 || meta.MaxTime > last.MaxTime {
+			last = meta
+		}
+
+		if !inBlockRange(meta) {
+			issues = append(issues, blockIssue{
+				Kind:    "range",
+				MinTime: meta.MinTime,
+				MaxTime: meta.MaxTime,
+				Blocks:  []string{meta.ULID.String()},
+			})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].MinTime < issues[j].MinTime
+	})
+	return issues
+}
+
+// inBlockRange tells whether the block fits in the aligned window of the
+// smallest of common.BlockRanges it isn't longer than, like the blocks
+// Prometheus writes and compacts.
+func inBlockRange(meta promTsdb.BlockMeta) bool {
+	for _, r := range common.BlockRanges {
+		if meta.MaxTime-meta.MinTime <= r {
+			return rangeStart(meta.MinTime, r) == rangeStart(meta.MaxTime-1, r)
+		}
+	}
+	return false
+}
+
+// rangeStart returns the start of the aligned range of width r that holds t.
+func rangeStart(t, r int64) int64 {
+	if t >= 0 {
+		return t - t%r
+	}
+	return t - (r+t%r)%r
+}
+
+// printParents prints the parents of a block as a tree. Parents are usually
+// deleted after the compaction, the ones still on disk are expanded too.
+func printParents(w io.Writer, parents []promTsdb.BlockDesc, onDisk map[string]promTsdb.BlockMeta, indent string) {
+	for i, parent := range parents {
+		branch, next := "├── ", "│   "
+		if i == len(parents)-1 {
+			branch, next = "└── ", "    "
+		}
+		state := "compacted"
+		meta, ok := onDisk[parent.ULID.String()]
+		if ok {
+			state = fmt.Sprintf("on disk, level %d", meta.Compaction.Level)
+		}
+		fmt.Fprintf(w, "%s%s%s\t%v\t%v\t%s\n",
+			indent,
+			branch,
+			parent.ULID,
+			time.Unix(parent.MinTime/1000, 0).Format(time.RFC3339),
+			time.Unix(parent.MaxTime/1000, 0).Format(time.RFC3339),
+			state,
+		)
+		if ok {
+			printParents(w, meta.Compaction.Parents, onDisk, indent+next)
+		}
+	}
+}
+
+// readBlockFiles returns the sizes of the files of the block directory.
+func readBlockFiles(dir string) (blockFiles, error) {
+	var files blockFiles
+
+	segments, err := ioutil.ReadDir(filepath.Join(dir, "chunks"))
+	if err != nil {
+		return files, err
+	}
+	for _, segment := range segments {
+		if !segment.IsDir() && !strings.HasPrefix(segment.Name(), ".") {
+			files.Chunks += segment.Size()
+			files.Segments++
+		}
+	}
+
+	index, err := os.Stat(filepath.Join(dir, "index"))
+	if err != nil {
+		return files, err
+	}
+	files.Index = index.Size()
+
+	// blocks without deletions may have no tombstones file
+	tombstones, err := os.Stat(filepath.Join(dir, "tombstones"))
+	if err == nil {
+		files.Tombstones = tombstones.Size()
+	} else if !os.IsNotExist(err) {
+		return files, err
+	}
+
+	return files, nil
+}
+
 func init() {
 	rootCmd.AddCommand(blocksCmd)
+	blocksCmd.PersistentFlags().BoolVar(&tree, "tree", false, "To show the compaction lineage of the blocks.")
+	blocksCmd.PersistentFlags().BoolVar(&check, "check", false, "To report overlapping blocks, gaps and blocks that don't fit the block ranges.")
 }
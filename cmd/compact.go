@@ -0,0 +1,228 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var outputDir string
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "To merge blocks into a new block in a separate directory",
+	Long: `
+Compacts the given blocks into a single block with the leveled compactor of the TSDB, the way Prometheus compacts them, and
+writes it into --output-dir. Overlapping blocks are merged, deleted data is dropped. The new block takes the time range of the
+given blocks and the next compaction level.
+
+The source blocks are never modified. Opening a block rewrites its meta.json, so the compactor gets a staging copy of every
+block: a copy of its meta.json and links to its index, chunks and tombstones.
+
+Check the new block with the blocks and verify commands before shipping it to Prometheus, and remove the source blocks there,
+or Prometheus holds the data twice.
+
+NOTE: It reads and writes every sample of the given blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo compact --storage.tsdb.path.copy=/my/prometheus/path/data --block=01D1EFWJ44G9WGN7AQ9398G2W2,01D1EFWJRQ35VYNT2M4YYEJV3R --output-dir=/tmp/compacted
+  ID                            FROM                         UNTIL                        DURATION    LEVEL    SOURCES    SERIES     SAMPLES        SIZE
+  01D1F3P5ZBX6QMGKE7Y0NBQ8W1    2019-01-16T07:00:00+01:00    2019-01-16T11:00:00+01:00    4h          2        2          310,512    41,268,774     168.7 MiB
+
+  Inspect it with: tsdbinfo blocks --storage.tsdb.path.copy=/tmp/compacted
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if len(blockIds) == 0 {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		if outputDir == "" {
+			fmt.Fprintln(os.Stderr, "error: set --output-dir")
+			os.Exit(2)
+		}
+
+		source, err := filepath.Abs(storagePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+		dest, err := filepath.Abs(outputDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+		if dest == source {
+			fmt.Fprintln(os.Stderr, "error: --output-dir must not be the TSDB path")
+			os.Exit(2)
+		}
+
+		var dirs []string
+		for _, id := range blockIds {
+			dir := filepath.Join(storagePath, id)
+			if _, err := os.Stat(filepath.Join(dir, "meta.json")); err != nil {
+				fmt.Fprintf(os.Stderr, "error: can't find block with id %s", id)
+				os.Exit(2)
+			}
+			dirs = append(dirs, dir)
+		}
+
+		if err := os.MkdirAll(dest, 0777); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+
+		id, err := compactBlocks(dest, dirs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: compacting the blocks failed: %s", err)
+			os.Exit(1)
+		}
+		if id == "" {
+			fmt.Println("The blocks have no samples, no block was written.")
+			return
+		}
+
+		metas, err := readBlockMetas(dest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+		files, err := readBlockFiles(filepath.Join(dest, id))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading the files of block %s failed: %s", id, err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		p := message.NewPrinter(language.English)
+		fmt.Fprintln(w, "ID\tFROM\tUNTIL\tDURATION\tLEVEL\tSOURCES\tSERIES\tSAMPLES\tSIZE")
+		for _, meta := range metas {
+			if meta.ULID.String() != id {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%d\t%d\t%v\t%v\t%s\n",
+				meta.ULID,
+				time.Unix(meta.MinTime/1000, 0).Format(time.RFC3339),
+				time.Unix(meta.MaxTime/1000, 0).Format(time.RFC3339),
+				model.Duration(time.Duration(meta.MaxTime-meta.MinTime)*time.Millisecond),
+				meta.Compaction.Level,
+				len(meta.Compaction.Sources),
+				p.Sprint(meta.Stats.NumSeries),
+				p.Sprint(meta.Stats.NumSamples),
+				formatBytes(int(files.Chunks+files.Index+files.Tombstones)),
+			)
+		}
+		w.Flush()
+
+		fmt.Printf("\nInspect it with: tsdbinfo blocks --storage.tsdb.path.copy=%s\n", outputDir)
+	},
+}
+
+// compactBlocks compacts the blocks of the directories into a new block in
+// dest, and returns its ID. It returns no ID if the blocks have no samples.
+// The compactor writes the meta.json of the blocks it opens, so it compacts
+// staging copies of the blocks, with their other files linked.
+func compactBlocks(dest string, dirs []string) (string, error) {
+	staging, err := ioutil.TempDir("", "tsdbinfo-compact")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	var staged []string
+	for _, dir := range dirs {
+		stagedDir, err := stageBlock(staging, dir)
+		if err != nil {
+			return "", err
+		}
+		staged = append(staged, stagedDir)
+	}
+
+	compactor, err := promTsdb.NewLeveledCompactor(context.Background(), nil, nil, common.BlockRanges, nil)
+	if err != nil {
+		return "", err
+	}
+	id, err := compactor.Compact(dest, staged, nil)
+	if err != nil {
+		return "", err
+	}
+	if id == (ulid.ULID{}) {
+		return "", nil
+	}
+	return id.String(), nil
+}
+
+// stageBlock creates the staging copy of a block under the staging
+// directory: a copy of the meta.json, and symlinks to the other files.
+func stageBlock(staging, dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	stagedDir := filepath.Join(staging, filepath.Base(dir))
+	if err := os.Mkdir(stagedDir, 0777); err != nil {
+		return "", err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, fi := range files {
+		from, to := filepath.Join(dir, fi.Name()), filepath.Join(stagedDir, fi.Name())
+		if fi.Name() != "meta.json" {
+			if err := os.Symlink(from, to); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b, err := ioutil.ReadFile(from)
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(to, b, 0666); err != nil {
+			return "", err
+		}
+	}
+	return stagedDir, nil
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+	compactCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to compact.")
+	compactCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "", "The directory to write the compacted block into. It must not be the TSDB path.")
+}
@@ -0,0 +1,335 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var blockRanges []string
+
+// compaction is a step of the compaction plan.
+type compaction struct {
+	Reason string
+	Meta   promTsdb.BlockMeta
+	Inputs []string
+	Size   int64
+}
+
+// compactPlanCmd represents the compact-plan command
+var compactPlanCmd = &cobra.Command{
+	Use:   "compact-plan",
+	Short: "To show what Prometheus would compact next",
+	Long: `
+Runs the leveled compaction planner of the TSDB on the blocks, the way Prometheus runs it after every head compaction, and lists
+the compactions in the order Prometheus would run them. Each compaction is planned with the blocks written by the previous ones,
+until there is nothing left to compact. The planner works on copies of the meta.json files, the blocks are only read.
+
+- overlap: blocks with overlapping time ranges are merged first, like after a backfill. Prometheus only does this with
+  --storage.tsdb.allow-overlapping-blocks.
+- level: blocks that fill a block range, or precede the newest block in it, are merged into a block of the next level. The
+  newest block is never compacted, it leaves a window to back up new blocks.
+- tombstones: a block of at least the middle block range with more than 5% tombstones per series is rewritten alone.
+
+The block ranges are the ones of Prometheus by default: 2h, growing by a factor of 3 up to the 10th step. --block-ranges
+overrides them, the first one being the range of the blocks written from the head.
+
+SIZE is an estimate: the size of the input blocks, without the tombstones files. The output is smaller when the tombstones
+delete data or the overlapping blocks carry the same samples.
+
+Example usage:
+
+  ➜  tsdbinfo compact-plan --storage.tsdb.path.copy=/my/prometheus/path/data
+  STEP    REASON     FROM                         UNTIL                        DURATION    LEVEL    SIZE         BLOCKS
+  1       level      2019-01-16T07:00:00+01:00    2019-01-16T13:00:00+01:00    6h          2        254.1 MiB    01D1EFWJ44G9WGN7AQ9398G2W2, 01D1EFWJRQ35VYNT2M4YYEJV3R, 01D1EG0K8E3EG6DAWRKW4TWJ9A
+  2       level      2019-01-16T01:00:00+01:00    2019-01-16T19:00:00+01:00    18h         3        762.0 MiB    01D1DQ4Q0NJ4RK5Y7SYWKNVMTB, step 1, 01D1EQQD5HR4WKRE2XEBDQ29RH
+
+  Prometheus would run 2 compactions, writing ~1016.1 MiB
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		ranges := common.BlockRanges
+		if len(blockRanges) > 0 {
+			ranges = nil
+			for _, r := range blockRanges {
+				d, err := model.ParseDuration(r)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: parsing --block-ranges failed: %s", err)
+					os.Exit(2)
+				}
+				r := int64(time.Duration(d) / time.Millisecond)
+				if r <= 0 || (len(ranges) > 0 && r <= ranges[len(ranges)-1]) {
+					fmt.Fprintln(os.Stderr, "error: --block-ranges must be positive and ascending")
+					os.Exit(2)
+				}
+				ranges = append(ranges, r)
+			}
+		}
+
+		metas, err := readBlockMetas(storagePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+
+		sizes := make(map[string]int64)
+		for _, meta := range metas {
+			files, err := readBlockFiles(filepath.Join(storagePath, meta.ULID.String()))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading the files of block %s failed: %s", meta.ULID, err)
+				os.Exit(1)
+			}
+			sizes[meta.ULID.String()] = files.Chunks + files.Index
+		}
+
+		steps, err := planCompactions(metas, ranges, sizes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: planning the compactions failed: %s", err)
+			os.Exit(1)
+		}
+
+		if len(steps) == 0 {
+			fmt.Println("Prometheus would not compact any blocks.")
+			return
+		}
+
+		step := make(map[string]string)
+		for i, c := range steps {
+			step[c.Meta.ULID.String()] = fmt.Sprintf("step %d", i+1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "STEP\tREASON\tFROM\tUNTIL\tDURATION\tLEVEL\tSIZE\tBLOCKS")
+		var total int64
+		for i, c := range steps {
+			var inputs []string
+			for _, id := range c.Inputs {
+				if s, ok := step[id]; ok {
+					id = s
+				}
+				inputs = append(inputs, id)
+			}
+			total += c.Size
+			fmt.Fprintf(w, "%d\t%s\t%v\t%v\t%s\t%d\t%s\t%s\n",
+				i+1,
+				c.Reason,
+				time.Unix(c.Meta.MinTime/1000, 0).Format(time.RFC3339),
+				time.Unix(c.Meta.MaxTime/1000, 0).Format(time.RFC3339),
+				model.Duration(time.Duration(c.Meta.MaxTime-c.Meta.MinTime)*time.Millisecond),
+				c.Meta.Compaction.Level,
+				formatBytes(int(c.Size)),
+				strings.Join(inputs, ", "),
+			)
+		}
+		w.Flush()
+
+		compactions := "compactions"
+		if len(steps) == 1 {
+			compactions = "compaction"
+		}
+		fmt.Printf("\nPrometheus would run %d %s, writing ~%s\n", len(steps), compactions, formatBytes(int(total)))
+	},
+}
+
+// readBlockMetas reads the meta.json of the blocks in the directory, without
+// opening the blocks.
+func readBlockMetas(dir string) ([]promTsdb.BlockMeta, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []promTsdb.BlockMeta
+	for _, fi := range files {
+		if _, err := ulid.Parse(fi.Name()); err != nil || !fi.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name(), "meta.json"))
+		if err != nil {
+			return nil, err
+		}
+		var meta promTsdb.BlockMeta
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return nil, fmt.Errorf("parsing the meta.json of block %s failed: %s", fi.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// planCompactions runs the compaction planner on the metas of the blocks
+// until there is nothing left to compact, and returns the compactions in
+// order. The planner reads the metas from a temporary directory, where every
+// planned compaction replaces its inputs with the meta of its output. The
+// sizes of the blocks are used for the size estimates, and completed with
+// the planned outputs.
+func planCompactions(metas []promTsdb.BlockMeta, ranges []int64, sizes map[string]int64) ([]compaction, error) {
+	dir, err := ioutil.TempDir("", "tsdbinfo-compact-plan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	planned := make(map[string]promTsdb.BlockMeta)
+	for _, meta := range metas {
+		if err := writeBlockMeta(dir, meta); err != nil {
+			return nil, err
+		}
+		planned[meta.ULID.String()] = meta
+	}
+
+	compactor, err := promTsdb.NewLeveledCompactor(context.Background(), nil, nil, ranges, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var steps []compaction
+	// every compaction merges blocks or drops tombstones, so the plan ends
+	for {
+		dirs, err := compactor.Plan(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(dirs) == 0 {
+			return steps, nil
+		}
+
+		var inputs []promTsdb.BlockMeta
+		for _, d := range dirs {
+			inputs = append(inputs, planned[filepath.Base(d)])
+		}
+
+		c := compactedMeta(ulid.MustNew(ulid.Now(), entropy), inputs)
+		for _, meta := range inputs {
+			c.Inputs = append(c.Inputs, meta.ULID.String())
+			c.Size += sizes[meta.ULID.String()]
+			delete(planned, meta.ULID.String())
+		}
+		sizes[c.Meta.ULID.String()] = c.Size
+		planned[c.Meta.ULID.String()] = c.Meta
+		steps = append(steps, c)
+
+		for _, d := range dirs {
+			if err := os.RemoveAll(d); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeBlockMeta(dir, c.Meta); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// compactedMeta is the meta of the block the compaction of the inputs writes,
+// the way the compactor fills it in. The compaction drops the tombstones.
+func compactedMeta(id ulid.ULID, inputs []promTsdb.BlockMeta) compaction {
+	meta := promTsdb.BlockMeta{
+		ULID:    id,
+		MinTime: math.MaxInt64,
+		MaxTime: math.MinInt64,
+		Version: 1,
+	}
+	sources := make(map[ulid.ULID]bool)
+	for _, input := range inputs {
+		if input.MinTime < meta.MinTime {
+			meta.MinTime = input.MinTime
+		}
+		if input.MaxTime > meta.MaxTime {
+			meta.MaxTime = input.MaxTime
+		}
+		if input.Compaction.Level > meta.Compaction.Level {
+			meta.Compaction.Level = input.Compaction.Level
+		}
+		for _, s := range input.Compaction.Sources {
+			sources[s] = true
+		}
+		meta.Compaction.Parents = append(meta.Compaction.Parents, promTsdb.BlockDesc{
+			ULID:    input.ULID,
+			MinTime: input.MinTime,
+			MaxTime: input.MaxTime,
+		})
+		meta.Stats.NumSamples += input.Stats.NumSamples
+		meta.Stats.NumChunks += input.Stats.NumChunks
+		if input.Stats.NumSeries > meta.Stats.NumSeries {
+			meta.Stats.NumSeries = input.Stats.NumSeries
+		}
+	}
+	meta.Compaction.Level++
+	for s := range sources {
+		meta.Compaction.Sources = append(meta.Compaction.Sources, s)
+	}
+	sort.Slice(meta.Compaction.Sources, func(i, j int) bool {
+		return meta.Compaction.Sources[i].Compare(meta.Compaction.Sources[j]) < 0
+	})
+
+	reason := "level"
+	if len(inputs) == 1 {
+		reason = "tombstones"
+	} else {
+		// the planner returns the inputs sorted by their min time
+		maxTime := inputs[0].MaxTime
+		for _, input := range inputs[1:] {
+			if input.MinTime < maxTime {
+				reason = "overlap"
+			}
+			if input.MaxTime > maxTime {
+				maxTime = input.MaxTime
+			}
+		}
+	}
+
+	return compaction{Reason: reason, Meta: meta}
+}
+
+// writeBlockMeta writes the meta.json of a block into dir/ULID.
+func writeBlockMeta(dir string, meta promTsdb.BlockMeta) error {
+	blockDir := filepath.Join(dir, meta.ULID.String())
+	if err := os.MkdirAll(blockDir, 0777); err != nil {
+		return err
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(blockDir, "meta.json"), b, 0666)
+}
+
+func init() {
+	rootCmd.AddCommand(compactPlanCmd)
+	compactPlanCmd.PersistentFlags().StringSliceVar(&blockRanges, "block-ranges", nil, "The block ranges to plan with, like 2h,6h,18h. Default: the block ranges of Prometheus")
+}
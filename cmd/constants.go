@@ -0,0 +1,194 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var minConstantRatio float64
+var intervalFactor int
+
+type constantStat struct {
+	Metric          string
+	Series          int
+	Constant        int
+	Zero            int
+	Samples         int
+	ConstantSamples int
+	ConstantBytes   int
+}
+
+// isConstant reports whether all the samples carry the same value, and whether
+// that value is zero.
+func isConstant(samples []sample) (constant bool, zero bool) {
+	if len(samples) == 0 {
+		return false, false
+	}
+	for _, s := range samples[1:] {
+		if s.V != samples[0].V {
+			return false, false
+		}
+	}
+	return true, samples[0].V == 0
+}
+
+// constantsCmd represents the constants command
+var constantsCmd = &cobra.Command{
+	Use:   "constants",
+	Short: "To find metrics whose series never change",
+	Long: `
+Finds metrics where most series hold the same value for the whole block, like build info gauges or counters stuck at zero.
+Such series cost a sample every scrape without carrying information. For each metric it estimates the samples and bytes saved
+by dropping the constant series, or by scraping them --interval-factor times less often.
+
+NOTE: It decodes every sample in the given block so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo constants --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --no-bar --top=3
+  METRIC                            SERIES    CONSTANT    ZERO     DROP SAMPLES    DROP BYTES     SLOWER SAMPLES    SLOWER BYTES
+  solr_metrics_core_timeouts_total  4,229     4,229       4,229    164,291,959     21,475,002     123,218,969       16,106,251
+  kube_pod_info                     2,210     2,208       0        85,763,880      11,210,422     64,322,910        8,407,816
+  jvm_info                          147       147         0        5,711,097       745,119        4,283,322         558,839
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if blockId == "" {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		if intervalFactor < 1 {
+			fmt.Fprintln(os.Stderr, "error: --interval-factor must be at least 1")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		block := findBlock(db, blockId)
+		if block == nil {
+			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+			os.Exit(2)
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(int(block.Meta().Stats.NumSeries))
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		byMetric := make(map[string]*constantStat)
+		err = forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+			if !no_bar {
+				bar.Incr()
+			}
+			metric := lset.Get("__name__")
+			stat, ok := byMetric[metric]
+			if !ok {
+				stat = &constantStat{Metric: metric}
+				byMetric[metric] = stat
+			}
+
+			numSamples := chunkSamples(chks)
+			stat.Series++
+			stat.Samples += numSamples
+
+			constant, zero := isConstant(decodeSamples(chks))
+			if constant {
+				stat.Constant++
+				stat.ConstantSamples += numSamples
+				stat.ConstantBytes += chunkBytes(chks)
+			}
+			if zero {
+				stat.Zero++
+			}
+		})
+
+		uiprogress.Stop()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading block failed: %s", err)
+			os.Exit(1)
+		}
+
+		var stat []*constantStat
+		for _, s := range byMetric {
+			if float64(s.Constant) >= minConstantRatio*float64(s.Series) && s.Constant > 0 {
+				stat = append(stat, s)
+			}
+		}
+
+		// metrics with most samples to save
+		sort.Slice(stat, func(i, j int) bool {
+			return stat[i].ConstantSamples > stat[j].ConstantSamples
+		})
+
+		if top < len(stat) {
+			stat = stat[:top]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "METRIC\tSERIES\tCONSTANT\tZERO\tDROP SAMPLES\tDROP BYTES\tSLOWER SAMPLES\tSLOWER BYTES")
+		p := message.NewPrinter(language.English)
+
+		// scraping every intervalFactor-th time keeps 1/intervalFactor of the samples
+		keep := 1 / float64(intervalFactor)
+		for _, s := range stat {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				s.Metric,
+				p.Sprint(s.Series),
+				p.Sprint(s.Constant),
+				p.Sprint(s.Zero),
+				p.Sprint(s.ConstantSamples),
+				p.Sprint(s.ConstantBytes),
+				p.Sprint(int(float64(s.ConstantSamples)*(1-keep))),
+				p.Sprint(int(float64(s.ConstantBytes)*(1-keep))),
+			)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(constantsCmd)
+	constantsCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
+	constantsCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	constantsCmd.PersistentFlags().Float64Var(&minConstantRatio, "min-ratio", 0.5, "Only list metrics where at least this ratio of the series is constant. Default: 0.5")
+	constantsCmd.PersistentFlags().IntVar(&intervalFactor, "interval-factor", 4, "The scrape interval multiplier used to estimate the savings of scraping constant series less often. Default: 4")
+	constantsCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -0,0 +1,185 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gosuri/uiprogress"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"golang.org/x/text/message"
+)
+
+// cost is the storage cost attributed to a group of series, like a target
+// or a team. A series stored in several blocks is counted once.
+type cost struct {
+	Group   []string
+	Samples int
+	Bytes   int
+	series  map[uint64]struct{}
+	metrics map[string]int
+}
+
+func (c *cost) Series() int {
+	return len(c.series)
+}
+
+// metricsBySamples returns the n metrics with most samples in the group.
+func (c *cost) metricsBySamples(n int) []string {
+	var metrics []string
+	for metric := range c.metrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if c.metrics[metrics[i]] != c.metrics[metrics[j]] {
+			return c.metrics[metrics[i]] > c.metrics[metrics[j]]
+		}
+		return metrics[i] < metrics[j]
+	})
+	if n < len(metrics) {
+		metrics = metrics[:n]
+	}
+	return metrics
+}
+
+// topMetrics returns the metrics with most samples in the group along with
+// their share of the group's samples.
+func (c *cost) topMetrics(n int, p *message.Printer) string {
+	var top []string
+	for _, metric := range c.metricsBySamples(n) {
+		top = append(top, p.Sprintf("%s (%.0f%%)", metric, percent(c.metrics[metric], c.Samples)))
+	}
+	return strings.Join(top, ", ")
+}
+
+// costs accumulates the cost of the series by group.
+type costs map[string]*cost
+
+func (c costs) add(group []string, lset promTsdbLabels.Labels, samples int, bytes int) {
+	key := strings.Join(group, "\xff")
+	groupCost, ok := c[key]
+	if !ok {
+		groupCost = &cost{
+			Group:   group,
+			series:  make(map[uint64]struct{}),
+			metrics: make(map[string]int),
+		}
+		c[key] = groupCost
+	}
+	groupCost.Samples += samples
+	groupCost.Bytes += bytes
+	groupCost.series[lset.Hash()] = struct{}{}
+	groupCost.metrics[lset.Get("__name__")] += samples
+}
+
+// sorted returns the groups with most samples first, along with the total
+// samples of all groups.
+func (c costs) sorted() ([]*cost, int) {
+	var sorted []*cost
+	var total int
+	for _, groupCost := range c {
+		sorted = append(sorted, groupCost)
+		total += groupCost.Samples
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Samples != sorted[j].Samples {
+			return sorted[i].Samples > sorted[j].Samples
+		}
+		return strings.Join(sorted[i].Group, ",") < strings.Join(sorted[j].Group, ",")
+	})
+	return sorted, total
+}
+
+// regroup merges the groups into the groups returned by the group function,
+// like targets into their jobs.
+func (c costs) regroup(group func(c *cost) []string) costs {
+	merged := make(costs)
+	for _, groupCost := range c {
+		g := group(groupCost)
+		key := strings.Join(g, "\xff")
+		m, ok := merged[key]
+		if !ok {
+			m = &cost{
+				Group:   g,
+				series:  make(map[uint64]struct{}),
+				metrics: make(map[string]int),
+			}
+			merged[key] = m
+		}
+		m.Samples += groupCost.Samples
+		m.Bytes += groupCost.Bytes
+		for hash := range groupCost.series {
+			m.series[hash] = struct{}{}
+		}
+		for metric, samples := range groupCost.metrics {
+			m.metrics[metric] += samples
+		}
+	}
+	return merged
+}
+
+// collectCosts attributes the cost of every series in the blocks to the group
+// returned by the group function. Series without a group are skipped.
+func collectCosts(blocks []*promTsdb.Block, group func(lset promTsdbLabels.Labels) []string) (costs, error) {
+	var numSeries int
+	for _, block := range blocks {
+		numSeries += int(block.Meta().Stats.NumSeries)
+	}
+
+	uiprogress.Start()
+	defer uiprogress.Stop()
+	var bar *uiprogress.Bar
+	if !no_bar {
+		bar = uiprogress.AddBar(numSeries)
+		bar.AppendCompleted()
+		bar.PrependElapsed()
+	}
+
+	c := make(costs)
+	for _, block := range blocks {
+		err := forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+			if !no_bar {
+				bar.Incr()
+			}
+			if g := group(lset); g != nil {
+				c.add(g, lset, chunkSamples(chks), chunkBytes(chks))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// groupValues returns the values of the given labels of the series.
+func groupValues(lset promTsdbLabels.Labels, by []string) []string {
+	values := make([]string, len(by))
+	for i, label := range by {
+		values[i] = lset.Get(label)
+	}
+	return values
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
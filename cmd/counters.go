@@ -0,0 +1,255 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var showSeries bool
+
+// counterSuffixes are the metric name suffixes of counter-like series.
+var counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+
+type counterStat struct {
+	Name      string
+	Series    int
+	Resets    int
+	Decreases int
+	Flat      int
+}
+
+// counterBehaviour counts the resets and the decreases of a counter series, and
+// reports whether it never increased. PromQL takes every drop for a reset, but
+// _total, _count and _bucket series only drop when the target restarts and
+// counts from zero again: for them only a drop from a positive value to below
+// half of it is a reset, other drops are decreases which counters must never
+// do. A _sum may go down with negative observations, all its drops are resets.
+func counterBehaviour(samples []sample, monotonic bool) (resets int, decreases int, flat bool) {
+	flat = true
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1].V, samples[i].V
+		switch {
+		case cur < prev && (!monotonic || prev > 0 && cur >= 0 && cur < prev/2):
+			resets++
+		case cur < prev:
+			decreases++
+		case cur > prev:
+			flat = false
+		}
+	}
+	return resets, decreases, flat
+}
+
+// countersCmd represents the counters command
+var countersCmd = &cobra.Command{
+	Use:   "counters",
+	Short: "To find counter resets and misbehaving counters in a given block",
+	Long: `
+Decodes the samples of every series whose name ends in ` + strings.Join(counterSuffixes, ", ") + ` and checks them for counter sanity.
+
+- RESETS: the value dropped below half of the previous one. Frequent resets point to crash-looping targets.
+  A _sum may go down with negative observations, every drop of a _sum is a reset.
+- DECREASES: the value went down, but not enough to be a reset, or it went negative. Counters must never go down, these
+  point to exporters misusing the counter type.
+- FLAT: series that never increased in the block.
+
+Resets are also summed up per instance.
+
+NOTE: It decodes every counter sample in the given block so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo counters --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --no-bar --top=3
+  METRIC                           SERIES    RESETS    DECREASES    FLAT
+  http_server_requests_total       582       1,164     0            12
+  process_cpu_seconds_total        14        28        0            0
+  queue_depth_total                14        0         3,310        2
+
+  INSTANCE           RESETS
+  10.0.3.17:8080     1,102
+  10.0.3.22:8080     90
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if blockId == "" {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		block := findBlock(db, blockId)
+		if block == nil {
+			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+			os.Exit(2)
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(int(block.Meta().Stats.NumSeries))
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		byMetric := make(map[string]*counterStat)
+		byInstance := make(map[string]*counterStat)
+		var bySeries []*counterStat
+		err = forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+			if !no_bar {
+				bar.Incr()
+			}
+			metric := lset.Get("__name__")
+			if !hasSuffix(metric, counterSuffixes) {
+				return
+			}
+			resets, decreases, flat := counterBehaviour(decodeSamples(chks), !strings.HasSuffix(metric, "_sum"))
+
+			stat, ok := byMetric[metric]
+			if !ok {
+				stat = &counterStat{Name: metric}
+				byMetric[metric] = stat
+			}
+			stat.Series++
+			stat.Resets += resets
+			stat.Decreases += decreases
+			if flat {
+				stat.Flat++
+			}
+
+			instance := lset.Get("instance")
+			istat, ok := byInstance[instance]
+			if !ok {
+				istat = &counterStat{Name: instance}
+				byInstance[instance] = istat
+			}
+			istat.Series++
+			istat.Resets += resets
+
+			if showSeries && (resets > 0 || decreases > 0 || flat) {
+				bySeries = append(bySeries, &counterStat{lset.String(), 1, resets, decreases, boolToInt(flat)})
+			}
+		})
+
+		uiprogress.Stop()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading block failed: %s", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		p := message.NewPrinter(language.English)
+
+		var stat []*counterStat
+		for _, s := range byMetric {
+			stat = append(stat, s)
+		}
+		sortCounterStats(stat)
+		fmt.Fprintln(w, "METRIC\tSERIES\tRESETS\tDECREASES\tFLAT")
+		for _, s := range limitCounterStats(stat) {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\n", s.Name, p.Sprint(s.Series), p.Sprint(s.Resets), p.Sprint(s.Decreases), p.Sprint(s.Flat))
+		}
+
+		var istat []*counterStat
+		for _, s := range byInstance {
+			if s.Resets > 0 {
+				istat = append(istat, s)
+			}
+		}
+		sortCounterStats(istat)
+		if len(istat) > 0 {
+			fmt.Fprintln(w, "\nINSTANCE\tRESETS")
+			for _, s := range limitCounterStats(istat) {
+				fmt.Fprintf(w, "%s\t%v\n", s.Name, p.Sprint(s.Resets))
+			}
+		}
+
+		if showSeries && len(bySeries) > 0 {
+			sortCounterStats(bySeries)
+			fmt.Fprintln(w, "\nSERIES\tRESETS\tDECREASES\tFLAT")
+			for _, s := range bySeries {
+				fmt.Fprintf(w, "%s\t%v\t%v\t%v\n", s.Name, p.Sprint(s.Resets), p.Sprint(s.Decreases), s.Flat == 1)
+			}
+		}
+		w.Flush()
+	},
+}
+
+// sortCounterStats puts the most resets first, then the most decreases.
+func sortCounterStats(stat []*counterStat) {
+	sort.Slice(stat, func(i, j int) bool {
+		if stat[i].Resets != stat[j].Resets {
+			return stat[i].Resets > stat[j].Resets
+		}
+		if stat[i].Decreases != stat[j].Decreases {
+			return stat[i].Decreases > stat[j].Decreases
+		}
+		return stat[i].Flat > stat[j].Flat
+	})
+}
+
+func limitCounterStats(stat []*counterStat) []*counterStat {
+	if top < len(stat) {
+		return stat[:top]
+	}
+	return stat
+}
+
+func hasSuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(countersCmd)
+	countersCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
+	countersCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	countersCmd.PersistentFlags().BoolVar(&showSeries, "series", false, "Lists every series with resets, decreases or no increase.")
+	countersCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func samplesOf(values ...float64) []sample {
+	samples := make([]sample, len(values))
+	for i, v := range values {
+		samples[i] = sample{T: int64(i) * 15000, V: v}
+	}
+	return samples
+}
+
+func TestCounterBehaviour(t *testing.T) {
+	cases := []struct {
+		name      string
+		values    []float64
+		monotonic bool
+		resets    int
+		decreases int
+		flat      bool
+	}{
+		{
+			name:      "increasing",
+			values:    []float64{1, 2, 3},
+			monotonic: true,
+		},
+		{
+			name:      "flat",
+			values:    []float64{5, 5, 5},
+			monotonic: true,
+			flat:      true,
+		},
+		{
+			name:      "reset",
+			values:    []float64{10, 20, 1, 5},
+			monotonic: true,
+			resets:    1,
+		},
+		{
+			name:      "decrease",
+			values:    []float64{10, 20, 15, 30},
+			monotonic: true,
+			decreases: 1,
+		},
+		{
+			name:      "negative values",
+			values:    []float64{-10, -8, -9, -20},
+			monotonic: true,
+			decreases: 2,
+		},
+		{
+			name:      "drop below zero",
+			values:    []float64{10, 20, -1},
+			monotonic: true,
+			decreases: 1,
+		},
+		{
+			name:   "sum going down",
+			values: []float64{10, 20, 15, -5, 3},
+			resets: 2,
+		},
+	}
+
+	for _, c := range cases {
+		resets, decreases, flat := counterBehaviour(samplesOf(c.values...), c.monotonic)
+		if resets != c.resets || decreases != c.decreases || flat != c.flat {
+			t.Errorf("%s: got %d resets, %d decreases, flat %v, want %d, %d, %v",
+				c.name, resets, decreases, flat, c.resets, c.decreases, c.flat)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	promTsdb "github.com/prometheus/tsdb"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+)
+
+type metricFamily struct {
+	Name    string
+	Type    string
+	Series  int
+	Samples int
+	Members []metricStat
+}
+
+// familyOf guesses the metric family and its type from the metric name
+// suffixes and label shape, as the TSDB doesn't store metric types.
+// labelNames holds the label names of every metric in the block.
+func familyOf(metric string, labelNames map[string]map[string]bool) (family string, metricType string) {
+	switch {
+	case strings.HasSuffix(metric, "_bucket") && labelNames[metric]["le"]:
+		return strings.TrimSuffix(metric, "_bucket"), typeHistogram
+	case strings.HasSuffix(metric, "_sum") || strings.HasSuffix(metric, "_count"):
+		base := strings.TrimSuffix(strings.TrimSuffix(metric, "_sum"), "_count")
+		if labelNames[base+"_bucket"]["le"] {
+			return base, typeHistogram
+		}
+		if labelNames[base]["quantile"] {
+			return base, typeSummary
+		}
+		if labelNames[base+"_sum"] != nil && labelNames[base+"_count"] != nil {
+			return base, typeSummary
+		}
+		// a lone _sum or _count may be anything, like a gauge of a count
+		return metric, typeGauge
+	case labelNames[metric]["quantile"]:
+		return metric, typeSummary
+	case strings.HasSuffix(metric, "_total"):
+		return metric, typeCounter
+	}
+	return metric, typeGauge
+}
+
+// metricFamilies groups the metric stats into families. The members of a
+// family keep the order of the given stats.
+func metricFamilies(stat []metricStat, block promTsdb.BlockReader) []*metricFamily {
+	labelNames := make(map[string]map[string]bool)
+	for _, s := range stat {
+		labelNames[s.Metric] = make(map[string]bool)
+		for label := range rawLabelStats(s.Metric, block) {
+			labelNames[s.Metric][label] = true
+		}
+	}
+
+	var families []*metricFamily
+	byName := make(map[string]*metricFamily)
+	for _, s := range stat {
+		name, metricType := familyOf(s.Metric, labelNames)
+		family, ok := byName[name]
+		if !ok {
+			family = &metricFamily{Name: name, Type: metricType}
+			byName[name] = family
+			families = append(families, family)
+		}
+		family.Series += s.Series
+		family.Samples += s.Samples
+		family.Members = append(family.Members, s)
+	}
+
+	return families
+}
+
+// familyLabelStats counts the distinct label values across all members of
+// the family.
+func familyLabelStats(family *metricFamily, block promTsdb.BlockReader) []labelStat {
+	values := make(map[string]map[string]bool)
+	for _, member := range family.Members {
+		for label, v := range rawLabelStats(member.Metric, block) {
+			if values[label] == nil {
+				values[label] = make(map[string]bool)
+			}
+			for value := range v {
+				values[label][value] = true
+			}
+		}
+	}
+
+	var stat []labelStat
+	for label, v := range values {
+		if label == "__name__" {
+			continue
+		}
+		stat = append(stat, labelStat{label, len(v)})
+	}
+	return stat
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestFamilyOf(t *testing.T) {
+	labelNames := map[string]map[string]bool{
+		"http_duration_seconds_bucket": {"le": true},
+		"http_duration_seconds_sum":    {},
+		"http_duration_seconds_count":  {},
+		"rpc_seconds":                  {"quantile": true},
+		"rpc_seconds_sum":              {},
+		"rpc_seconds_count":            {},
+		"queue_count":                  {},
+		"disk_sum":                     {},
+		"requests_total":               {},
+		"temperature":                  {},
+	}
+	cases := []struct {
+		metric     string
+		family     string
+		metricType string
+	}{
+		{"http_duration_seconds_bucket", "http_duration_seconds", typeHistogram},
+		{"http_duration_seconds_sum", "http_duration_seconds", typeHistogram},
+		{"http_duration_seconds_count", "http_duration_seconds", typeHistogram},
+		{"rpc_seconds", "rpc_seconds", typeSummary},
+		{"rpc_seconds_count", "rpc_seconds", typeSummary},
+		{"queue_count", "queue_count", typeGauge},
+		{"disk_sum", "disk_sum", typeGauge},
+		{"requests_total", "requests_total", typeCounter},
+		{"temperature", "temperature", typeGauge},
+	}
+
+	for _, c := range cases {
+		family, metricType := familyOf(c.metric, labelNames)
+		if family != c.family || metricType != c.metricType {
+			t.Errorf("%s: got %s %s, want %s %s", c.metric, family, metricType, c.family, c.metricType)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+type histogramStat struct {
+	Histogram    string
+	Series       int
+	Buckets      int
+	Multiplier   float64
+	EmptyBuckets []string
+	SavedSeries  int
+	SavedSamples int
+	SavedBytes   int
+}
+
+// bucketSeries is what we keep of a single _bucket series to find out whether
+// it ever differs from the next lower bucket.
+type bucketSeries struct {
+	le      float64
+	leValue string
+	hash    uint64
+	zero    bool
+	samples int
+	bytes   int
+}
+
+// hashSamples fingerprints the samples of a series. Two buckets of the same
+// histogram are scraped at the same timestamps, so equal fingerprints mean the
+// buckets hold the same values.
+func hashSamples(samples []sample) uint64 {
+	h := fnv.New64a()
+	b := make([]byte, 16)
+	for _, s := range samples {
+		binary.LittleEndian.PutUint64(b, uint64(s.T))
+		binary.LittleEndian.PutUint64(b[8:], math.Float64bits(s.V))
+		h.Write(b)
+	}
+	return h.Sum64()
+}
+
+func allZero(samples []sample) bool {
+	for _, s := range samples {
+		if s.V != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func numSeries(b promTsdb.BlockReader, metric string) int {
+	var n int
+	forEachSeries(b, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+		n++
+	}, promTsdbLabels.NewEqualMatcher("__name__", metric))
+	return n
+}
+
+// histogramStats analyses the bucket layout of a single classic histogram.
+// A bucket never receives observations when it always holds the same value as
+// the next lower bucket, or when it is the lowest bucket and always zero. The
+// +Inf bucket is mandatory so it is never reported.
+func histogramStats(histogram string, b promTsdb.BlockReader) (histogramStat, error) {
+	stat := histogramStat{Histogram: histogram}
+
+	// bucket series grouped by their label set without le
+	groups := make(map[string][]bucketSeries)
+	les := make(map[string]bool)
+	err := forEachSeries(b, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+		leValue := lset.Get("le")
+		le, err := strconv.ParseFloat(leValue, 64)
+		if err != nil {
+			return
+		}
+		les[leValue] = true
+
+		var key promTsdbLabels.Labels
+		for _, l := range lset {
+			if l.Name != "le" {
+				key = append(key, l)
+			}
+		}
+
+		samples := decodeSamples(chks)
+		groups[key.String()] = append(groups[key.String()], bucketSeries{
+			le:      le,
+			leValue: leValue,
+			hash:    hashSamples(samples),
+			zero:    allZero(samples),
+			samples: chunkSamples(chks),
+			bytes:   chunkBytes(chks),
+		})
+		stat.Series++
+	}, promTsdbLabels.NewEqualMatcher("__name__", histogram+"_bucket"))
+	if err != nil {
+		return stat, err
+	}
+
+	stat.Buckets = len(les)
+	if len(groups) > 0 {
+		stat.Multiplier = float64(stat.Series) / float64(len(groups))
+	}
+	stat.Series += numSeries(b, histogram+"_sum") + numSeries(b, histogram+"_count")
+
+	// a bucket is empty if it is empty in every group it shows up in
+	empty := make(map[string]bool)
+	for _, buckets := range groups {
+		sort.Slice(buckets, func(i, j int) bool {
+			return buckets[i].le < buckets[j].le
+		})
+		for i, bucket := range buckets {
+			if math.IsInf(bucket.le, 1) {
+				continue
+			}
+			isEmpty := bucket.zero
+			if i > 0 {
+				isEmpty = bucket.hash == buckets[i-1].hash
+			}
+			if e, seen := empty[bucket.leValue]; !seen || e {
+				empty[bucket.leValue] = isEmpty
+			}
+		}
+	}
+
+	for _, buckets := range groups {
+		for _, bucket := range buckets {
+			if empty[bucket.leValue] {
+				stat.SavedSeries++
+				stat.SavedSamples += bucket.samples
+				stat.SavedBytes += bucket.bytes
+			}
+		}
+	}
+	for le, e := range empty {
+		if e {
+			stat.EmptyBuckets = append(stat.EmptyBuckets, le)
+		}
+	}
+	sort.Slice(stat.EmptyBuckets, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(stat.EmptyBuckets[i], 64)
+		b, _ := strconv.ParseFloat(stat.EmptyBuckets[j], 64)
+		return a < b
+	})
+
+	return stat, nil
+}
+
+// histogramsCmd represents the histograms command
+var histogramsCmd = &cobra.Command{
+	Use:   "histograms",
+	Short: "To analyse the bucket layout of the classic histograms in a given block",
+	Long: `
+Groups the _bucket, _sum and _count series of every classic histogram in a given block and analyses their bucket layout.
+
+- BUCKETS: the number of distinct le values
+- MULTIPLIER: the number of bucket series per label combination, that is how many times le multiplies the series
+- EMPTY: buckets that never receive observations, they always hold the same value as the next lower bucket
+- SAVED: the series, samples and bytes you save by removing the empty buckets
+
+NOTE: It decodes every bucket sample in the given block so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo histograms --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --no-bar --top=2
+  HISTOGRAM                             SERIES     BUCKETS    MULTIPLIER    EMPTY                SAVED SERIES    SAVED SAMPLES    SAVED BYTES
+  http_server_request_duration_seconds  26,190     15         15.00         0.005, 0.01, 30, 60  6,984           271,277,496      35,264,074
+  grpc_server_handling_seconds          11,520     12         12.00         0.005                960             37,290,240       4,847,731
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if blockId == "" {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		block := findBlock(db, blockId)
+		if block == nil {
+			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+			os.Exit(2)
+		}
+
+		indexReader, _ := block.Index()
+		var histograms []string
+		for _, metric := range metrics(indexReader) {
+			if strings.HasSuffix(metric, "_bucket") {
+				histograms = append(histograms, strings.TrimSuffix(metric, "_bucket"))
+			}
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(len(histograms))
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		var stat []histogramStat
+		for _, histogram := range histograms {
+			if !no_bar {
+				bar.Incr()
+			}
+			s, err := histogramStats(histogram, block)
+			if err != nil {
+				uiprogress.Stop()
+				fmt.Fprintf(os.Stderr, "error: reading histogram %s failed: %s", histogram, err)
+				os.Exit(1)
+			}
+			if s.Buckets > 0 {
+				stat = append(stat, s)
+			}
+		}
+
+		uiprogress.Stop()
+
+		// histograms with most series
+		sort.Slice(stat, func(i, j int) bool {
+			return stat[i].Series > stat[j].Series
+		})
+
+		if top < len(stat) {
+			stat = stat[:top]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "HISTOGRAM\tSERIES\tBUCKETS\tMULTIPLIER\tEMPTY\tSAVED SERIES\tSAVED SAMPLES\tSAVED BYTES")
+		p := message.NewPrinter(language.English)
+
+		for _, s := range stat {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%.2f\t%s\t%v\t%v\t%v\n",
+				s.Histogram,
+				p.Sprint(s.Series),
+				p.Sprint(s.Buckets),
+				s.Multiplier,
+				strings.Join(s.EmptyBuckets, ", "),
+				p.Sprint(s.SavedSeries),
+				p.Sprint(s.SavedSamples),
+				p.Sprint(s.SavedBytes),
+			)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(histogramsCmd)
+	histogramsCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
+	histogramsCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	histogramsCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
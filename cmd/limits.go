@@ -0,0 +1,291 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/laszlocph/tsdbinfo/pkg/promconfig"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var headroom float64
+var fleetSampleLimit int
+var fleetLabelLimit int
+var fleetLabelValueLengthLimit int
+
+// scrapeSeries are the series Prometheus adds to every scrape itself. The
+// scrape limits don't apply to them.
+var scrapeSeries = map[string]bool{
+	"up":                                    true,
+	"scrape_duration_seconds":               true,
+	"scrape_samples_scraped":                true,
+	"scrape_samples_post_metric_relabeling": true,
+	"scrape_series_added":                   true,
+}
+
+// jobLimits is the observed maximum of each scrape limit of a job.
+type jobLimits struct {
+	Job            string
+	Targets        int
+	MaxSamples     int
+	MaxLabels      int
+	MaxValueLength int
+
+	targets map[string]struct{}
+}
+
+// limitsCmd represents the limits command
+var limitsCmd = &cobra.Command{
+	Use:   "limits",
+	Short: "To propose sample_limit, label_limit and label_value_length_limit for each job",
+	Long: `
+Proposes scrape limits for each job from the selected blocks: the observed maximum plus --headroom. Select blocks with
+--block, or leave it empty to use all of them.
+
+The samples per scrape are the samples a target wrote at the same timestamp, without the up and scrape_* series that
+Prometheus adds to every scrape. Label counts and value lengths include the metric name, like Prometheus does when it
+enforces the limits.
+
+Pass the fleet-wide limits you plan to roll out with --sample-limit, --label-limit and --label-value-length-limit to flag
+the jobs that would already exceed them. With --config.file it flags the jobs whose configured limits leave less than the
+headroom.
+
+NOTE: It decodes every sample in the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo limits --storage.tsdb.path.copy=/my/prometheus/path/data --sample-limit=10000 --no-bar
+  JOB                TARGETS    MAX SAMPLES    SAMPLE_LIMIT    MAX LABELS    LABEL_LIMIT    MAX VALUE LENGTH    LABEL_VALUE_LENGTH_LIMIT    NOTES
+  solr               3          27,070         32,484          9             11             212                 255                         exceeds sample_limit 10,000
+  node               12         1,931          2,318           7             9              96                  116
+  kubernetes-pods    40         582            699             14            17             63                  76
+
+  Fleet-wide limits that fit every job: sample_limit=32,484 label_limit=17 label_value_length_limit=255
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if headroom < 0 {
+			fmt.Fprintln(os.Stderr, "error: --headroom can't be negative")
+			os.Exit(2)
+		}
+
+		var config *promconfig.Config
+		if configFile != "" {
+			var err error
+			config, err = promconfig.Load(configFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s", err)
+				os.Exit(2)
+			}
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		var numSeries int
+		for _, block := range blocks {
+			numSeries += int(block.Meta().Stats.NumSeries)
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(numSeries)
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		jobs := make(map[string]*jobLimits)
+		for _, block := range blocks {
+			// samples by target and timestamp, per block to bound the memory use
+			scrapes := make(map[string]map[int64]int)
+			err := forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+				if !no_bar {
+					bar.Incr()
+				}
+				job := lset.Get("job")
+				stat, ok := jobs[job]
+				if !ok {
+					stat = &jobLimits{Job: job, targets: make(map[string]struct{})}
+					jobs[job] = stat
+				}
+
+				target := job + "\xff" + lset.Get("instance")
+				stat.targets[target] = struct{}{}
+				if scrapeSeries[lset.Get("__name__")] {
+					return
+				}
+				if len(lset) > stat.MaxLabels {
+					stat.MaxLabels = len(lset)
+				}
+				for _, l := range lset {
+					if len(l.Value) > stat.MaxValueLength {
+						stat.MaxValueLength = len(l.Value)
+					}
+				}
+
+				timestamps, ok := scrapes[target]
+				if !ok {
+					timestamps = make(map[int64]int)
+					scrapes[target] = timestamps
+				}
+				for _, s := range decodeSamples(chks) {
+					timestamps[s.T]++
+				}
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading block %s failed: %s", block.Meta().ULID, err)
+				os.Exit(1)
+			}
+
+			for target, timestamps := range scrapes {
+				stat := jobs[strings.SplitN(target, "\xff", 2)[0]]
+				for _, n := range timestamps {
+					if n > stat.MaxSamples {
+						stat.MaxSamples = n
+					}
+				}
+			}
+		}
+		uiprogress.Stop()
+
+		var stat []*jobLimits
+		for _, job := range jobs {
+			job.Targets = len(job.targets)
+			stat = append(stat, job)
+		}
+		sort.Slice(stat, func(i, j int) bool {
+			if stat[i].MaxSamples != stat[j].MaxSamples {
+				return stat[i].MaxSamples > stat[j].MaxSamples
+			}
+			return stat[i].Job < stat[j].Job
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "JOB\tTARGETS\tMAX SAMPLES\tSAMPLE_LIMIT\tMAX LABELS\tLABEL_LIMIT\tMAX VALUE LENGTH\tLABEL_VALUE_LENGTH_LIMIT\tNOTES")
+		p := message.NewPrinter(language.English)
+
+		var fleetSamples, fleetLabels, fleetValueLength int
+		for i, job := range stat {
+			sampleLimit := withHeadroom(job.MaxSamples, headroom)
+			labelLimit := withHeadroom(job.MaxLabels, headroom)
+			valueLengthLimit := withHeadroom(job.MaxValueLength, headroom)
+			fleetSamples = maxInt(fleetSamples, sampleLimit)
+			fleetLabels = maxInt(fleetLabels, labelLimit)
+			fleetValueLength = maxInt(fleetValueLength, valueLengthLimit)
+
+			var notes []string
+			notes = append(notes, exceedsLimit(p, "sample_limit", job.MaxSamples, fleetSampleLimit)...)
+			notes = append(notes, exceedsLimit(p, "label_limit", job.MaxLabels, fleetLabelLimit)...)
+			notes = append(notes, exceedsLimit(p, "label_value_length_limit", job.MaxValueLength, fleetLabelValueLengthLimit)...)
+			if config != nil {
+				if sc := config.Job(job.Job); sc != nil {
+					notes = append(notes, tightLimit(p, "sample_limit", sc.SampleLimit, sampleLimit)...)
+					notes = append(notes, tightLimit(p, "label_limit", sc.LabelLimit, labelLimit)...)
+					notes = append(notes, tightLimit(p, "label_value_length_limit", sc.LabelValueLengthLimit, valueLengthLimit)...)
+				} else {
+					notes = append(notes, "not in the config")
+				}
+			}
+
+			// all jobs count for the fleet-wide limits, only the top ones are listed
+			if i >= top {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%s\n",
+				job.Job,
+				p.Sprint(job.Targets),
+				p.Sprint(job.MaxSamples),
+				p.Sprint(sampleLimit),
+				p.Sprint(job.MaxLabels),
+				p.Sprint(labelLimit),
+				p.Sprint(job.MaxValueLength),
+				p.Sprint(valueLengthLimit),
+				strings.Join(notes, ", "),
+			)
+		}
+		w.Flush()
+
+		p.Printf("\nFleet-wide limits that fit every job: sample_limit=%d label_limit=%d label_value_length_limit=%d\n",
+			fleetSamples, fleetLabels, fleetValueLength)
+	},
+}
+
+// withHeadroom returns the limit that leaves the given headroom over the
+// observed maximum.
+func withHeadroom(max int, headroom float64) int {
+	return int(math.Ceil(float64(max) * (1 + headroom)))
+}
+
+// exceedsLimit notes when the observed maximum is over the planned limit.
+func exceedsLimit(p *message.Printer, name string, max int, limit int) []string {
+	if limit > 0 && max > limit {
+		return []string{p.Sprintf("exceeds %s %d", name, limit)}
+	}
+	return nil
+}
+
+// tightLimit notes when the configured limit is below the proposed one.
+func tightLimit(p *message.Printer, name string, configured int, proposed int) []string {
+	if configured > 0 && configured < proposed {
+		return []string{p.Sprintf("configured %s %d is below the proposal", name, configured)}
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func init() {
+	rootCmd.AddCommand(limitsCmd)
+	limitsCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	limitsCmd.PersistentFlags().Float64Var(&headroom, "headroom", 0.2, "The headroom over the observed maximum, 0.2 means 20%.")
+	limitsCmd.PersistentFlags().IntVar(&fleetSampleLimit, "sample-limit", 0, "The fleet-wide sample_limit to check the jobs against.")
+	limitsCmd.PersistentFlags().IntVar(&fleetLabelLimit, "label-limit", 0, "The fleet-wide label_limit to check the jobs against.")
+	limitsCmd.PersistentFlags().IntVar(&fleetLabelValueLengthLimit, "label-value-length-limit", 0, "The fleet-wide label_value_length_limit to check the jobs against.")
+	limitsCmd.PersistentFlags().StringVar(&configFile, "config.file", "", "Optional prometheus.yml to compare with the configured limits.")
+	limitsCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	limitsCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
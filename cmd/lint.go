@@ -0,0 +1,301 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	camelCaseRE  = regexp.MustCompile(`[a-z0-9][A-Z]`)
+
+	// nonBaseUnits maps the name parts of non-base units to the base unit
+	// that should be used instead.
+	nonBaseUnits = map[string]string{
+		"nanoseconds": "seconds", "microseconds": "seconds", "milliseconds": "seconds",
+		"ns": "seconds", "us": "seconds", "ms": "seconds",
+		"minutes": "seconds", "hours": "seconds", "days": "seconds", "weeks": "seconds",
+		"kilobytes": "bytes", "megabytes": "bytes", "gigabytes": "bytes", "terabytes": "bytes",
+		"kb": "bytes", "mb": "bytes", "gb": "bytes", "kib": "bytes", "mib": "bytes", "gib": "bytes",
+		"bits":    "bytes",
+		"percent": "ratio", "percentage": "ratio",
+		"fahrenheit": "celsius", "kelvin": "celsius",
+		"millivolts": "volts", "milliamperes": "amperes", "kilograms": "grams",
+	}
+
+	baseUnits = []string{"seconds", "bytes", "ratio", "celsius", "volts", "amperes", "joules", "grams", "meters", "hertz"}
+
+	typeNames = []string{"counter", "gauge", "histogram", "summary"}
+
+	// typeSuffixes may follow the unit in a metric name.
+	typeSuffixes = []string{"total", "count", "sum", "bucket", "info", "created"}
+)
+
+type lintFinding struct {
+	Metric   string
+	Severity string
+	Check    string
+	Message  string
+}
+
+// lintMetric checks the metric and its label names against the Prometheus
+// naming best practices: https://prometheus.io/docs/practices/naming/
+// labels holds the label values of the metric by label name.
+func lintMetric(metric string, labels map[string]map[string]bool, counter bool) []lintFinding {
+	var findings []lintFinding
+	report := func(severity, check, format string, a ...interface{}) {
+		findings = append(findings, lintFinding{metric, severity, check, fmt.Sprintf(format, a...)})
+	}
+
+	if !metricNameRE.MatchString(metric) {
+		report(severityError, "metric-name", "%q is not a valid metric name", metric)
+	}
+	if camelCaseRE.MatchString(metric) {
+		report(severityWarning, "camel-case", "metric names should be snake_case")
+	}
+	// _count and _sum are the counter suffixes of histograms and summaries
+	if counter && !hasSuffix(metric, []string{"_total", "_count", "_sum"}) {
+		report(severityWarning, "counter-suffix", "looks like a counter but doesn't end in _total")
+	}
+
+	parts := strings.Split(strings.ToLower(metric), "_")
+	for _, part := range parts {
+		if base, ok := nonBaseUnits[part]; ok {
+			report(severityWarning, "base-unit", "uses %s, use the base unit %s instead", part, base)
+		}
+		for _, typeName := range typeNames {
+			if part == typeName {
+				report(severityWarning, "type-name", "contains the metric type name %s", typeName)
+			}
+		}
+	}
+
+	// the unit is the last part of the name, apart from the type suffixes
+	last := len(parts) - 1
+	for last > 0 && contains(typeSuffixes, parts[last]) {
+		last--
+	}
+	for _, part := range parts[:last] {
+		if contains(baseUnits, part) && !contains(baseUnits, parts[last]) {
+			report(severityWarning, "unit-suffix", "the unit %s should be the suffix of the name", part)
+		}
+	}
+
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "__name__" {
+			continue
+		}
+		if strings.HasPrefix(name, "__") {
+			report(severityError, "reserved-label", "label %s uses the reserved __ prefix", name)
+		} else if !labelNameRE.MatchString(name) {
+			report(severityError, "label-name", "%q is not a valid label name", name)
+		}
+		if camelCaseRE.MatchString(name) {
+			report(severityWarning, "camel-case", "label %s should be snake_case", name)
+		}
+		if name == metric || labels[name][metric] {
+			report(severityWarning, "label-repeats-metric", "label %s repeats the metric name", name)
+		}
+	}
+
+	return findings
+}
+
+// looksLikeCounter reports whether all series of the metric only go up, apart
+// from rare resets, and at least one of them increased.
+func looksLikeCounter(metric string, block *promTsdb.Block) bool {
+	counter, increased := true, false
+	forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+		if !counter {
+			return
+		}
+		var seriesIncreased bool
+		counter, seriesIncreased = counterLike(decodeSamples(chks))
+		increased = increased || seriesIncreased
+	}, promTsdbLabels.NewEqualMatcher("__name__", metric))
+
+	return counter && increased
+}
+
+// counterLike reports whether the samples only go up apart from resets, and
+// whether they increased. Unlike counterBehaviour, a drop is only a reset if
+// it goes near zero, and resets must be rare: a gauge that keeps returning to
+// zero, like the requests in flight, is not a counter.
+func counterLike(samples []sample) (counter bool, increased bool) {
+	var increases, resets int
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1].V, samples[i].V
+		switch {
+		case cur > prev:
+			increases++
+		case cur < prev && cur <= prev/10:
+			resets++
+		case cur < prev:
+			return false, increases > 0
+		}
+	}
+	return resets*10 <= increases, increases > 0
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "To check metric and label names against the Prometheus naming best practices",
+	Long: `
+Checks every metric and label name in a given block against the Prometheus naming best practices: https://prometheus.io/docs/practices/naming/
+
+- metric-name, label-name: the name is not valid
+- reserved-label: labels starting with __ are reserved for internal use
+- counter-suffix: the series only go up, apart from rare resets, but the name doesn't end in _total, _count or _sum
+- base-unit: the name uses a non-base unit, like milliseconds or megabytes
+- unit-suffix: the unit is not the suffix of the name
+- type-name: the name contains a metric type, like counter or gauge
+- label-repeats-metric: a label name or value repeats the metric name
+- camel-case: names should be snake_case
+
+It exits with a non-zero code if it finds errors.
+
+NOTE: It decodes the samples of the metrics that are not named as counters so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo lint --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --no-bar
+  METRIC                        SEVERITY    CHECK             MESSAGE
+  jvm_gc_pause_milliseconds     warning     base-unit         uses milliseconds, use the base unit seconds instead
+  queueSizeCounter              warning     camel-case        metric names should be snake_case
+  queueSizeCounter              warning     counter-suffix    looks like a counter but doesn't end in _total
+  queueSizeCounter              error       reserved-label    label __tenant uses the reserved __ prefix
+
+  1 errors, 3 warnings
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if blockId == "" {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		block := findBlock(db, blockId)
+		if block == nil {
+			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+			os.Exit(2)
+		}
+
+		indexReader, _ := block.Index()
+		metrics := metrics(indexReader)
+		sort.Strings(metrics)
+
+		labelNames := make(map[string]map[string]bool)
+		labelValues := make(map[string]map[string]map[string]bool)
+		for _, metric := range metrics {
+			labelValues[metric] = rawLabelStats(metric, block)
+			labelNames[metric] = make(map[string]bool)
+			for label := range labelValues[metric] {
+				labelNames[metric][label] = true
+			}
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(len(metrics))
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		var findings []lintFinding
+		for _, metric := range metrics {
+			if !no_bar {
+				bar.Incr()
+			}
+			_, metricType := familyOf(metric, labelNames)
+			counter := metricType == typeCounter
+			if metricType == typeGauge {
+				counter = looksLikeCounter(metric, block)
+			}
+			findings = append(findings, lintMetric(metric, labelValues[metric], counter)...)
+		}
+
+		uiprogress.Stop()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "METRIC\tSEVERITY\tCHECK\tMESSAGE")
+		var errors, warnings int
+		for _, f := range findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Metric, f.Severity, f.Check, f.Message)
+			if f.Severity == severityError {
+				errors++
+			} else {
+				warnings++
+			}
+		}
+		fmt.Fprintf(w, "\n%d errors, %d warnings\n", errors, warnings)
+		w.Flush()
+
+		if errors > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
+	lintCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
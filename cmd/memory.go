@@ -0,0 +1,318 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// The memory model of the head of prometheus/tsdb v0.7.1 on 64 bit platforms.
+// The sizes are of the Go structures, rounded up to the allocation size class.
+const (
+	// headRange is the time the head holds, 1.5 times the 2h block range
+	// before the oldest 2h is compacted into a block.
+	headRange = int64(3 * time.Hour / time.Millisecond)
+	// memSeriesBytes is the memSeries struct with its lock, sample buffer and
+	// chunk appender.
+	memSeriesBytes = 192
+	// seriesRefBytes is the entries of a series in the series and hashes
+	// maps of stripeSeries.
+	seriesRefBytes = 64
+	// labelBytes is the name and value string headers of a label in
+	// labels.Labels. The strings themselves are held by each series.
+	labelBytes = 32
+	// postingBytes is the series reference in the postings list of a label
+	// pair.
+	postingBytes = 8
+	// termBytes is a label pair in the postings, values and symbols maps.
+	// The maps share the strings of the series.
+	termBytes = 96
+	// memChunkBytes is a memChunk with its XOR chunk and bit stream headers.
+	memChunkBytes = 72
+	// samplesPerChunk is the number of samples after which the head cuts
+	// a new chunk.
+	samplesPerChunk = 120
+)
+
+// memoryStat is the estimated head memory held by a metric.
+type memoryStat struct {
+	Metric   string
+	Series   int
+	Labels   int
+	Postings int
+	Chunks   int
+	Terms    int
+}
+
+func (s *memoryStat) SeriesBytes() int {
+	return s.Series * (memSeriesBytes + seriesRefBytes)
+}
+
+func (s *memoryStat) Total() int {
+	return s.SeriesBytes() + s.Labels + s.Postings + s.Chunks + s.Terms
+}
+
+// labelMemoryStat is the estimated head memory held by a label name.
+type labelMemoryStat struct {
+	Label  string
+	Series int
+	Values int
+	Bytes  int
+}
+
+// labelPair tells which metric uses a label pair, and whether other metrics
+// use it too. The memory of a pair is only freed when all its series go.
+type labelPair struct {
+	metric string
+	shared bool
+}
+
+// memoryCmd represents the memory command
+var memoryCmd = &cobra.Command{
+	Use:   "memory",
+	Short: "To estimate the head memory of each metric and label",
+	Long: `
+Estimates the RAM the series of the given block take in the head of Prometheus, for each metric and label.
+Only the series with samples in the last 3h of the block are counted, the head doesn't hold the series that churned before.
+The estimate follows the memory model of prometheus/tsdb v0.7.1:
+
+  STRUCTS     the memSeries structs and their entries in the series maps
+  LABELS      the label strings held by each series
+  POSTINGS    the series references in the postings lists
+  CHUNKS      the chunks of the last 3h, at the ingestion rate and compression of the block
+  TERMS       the label pairs in the postings, values and symbols maps, if no other metric uses them
+
+TOTAL is what dropping the metric saves. The label table shows what dropping the label saves,
+assuming the series stay unique without it.
+
+Example usage:
+
+  ➜  tsdbinfo memory --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --no-bar --top=3
+  METRIC                                  SERIES     STRUCTS     LABELS      POSTINGS    CHUNKS      TERMS       TOTAL
+  kube_pod_container_status_restarts      110,021    26.9 MiB    19.1 MiB    6.7 MiB     18.2 MiB    8.1 MiB     79.0 MiB
+  solr_metrics_core_time_seconds_total    4,229      1.0 MiB     1.1 MiB     330.4 KiB   712.5 KiB   23.8 KiB    3.2 MiB
+  jvm_buffer_pool_used_bytes              1,102      275.5 KiB   203.3 KiB   60.3 KiB    185.6 KiB   1.1 KiB     725.8 KiB
+
+  LABEL        VALUES     SERIES     RAM
+  pod          41,003     310,512    38.8 MiB
+  container    214        309,730    17.6 MiB
+  namespace    31         310,512    14.2 MiB
+
+  Dropping kube_pod_container_status_restarts saves ~79.0 MiB of RAM, 21% of the estimated 371.7 MiB head.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if blockId == "" {
+			fmt.Fprintln(os.Stderr, "error: set --block")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		block := findBlock(db, blockId)
+		if block == nil {
+			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+			os.Exit(2)
+		}
+
+		// the head holds the series of the last headRange, with headRange
+		// of samples at the rate of the block
+		meta := block.Meta()
+		windowStart := meta.MaxTime - headRange
+		if windowStart < meta.MinTime {
+			windowStart = meta.MinTime
+		}
+		scale := 1.0
+		if duration := meta.MaxTime - windowStart; duration > 0 {
+			scale = float64(headRange) / float64(duration)
+		}
+
+		uiprogress.Start()
+		var bar *uiprogress.Bar
+		if !no_bar {
+			bar = uiprogress.AddBar(int(meta.Stats.NumSeries))
+			bar.AppendCompleted()
+			bar.PrependElapsed()
+		}
+
+		byMetric := make(map[string]*memoryStat)
+		byLabel := make(map[string]*labelMemoryStat)
+		pairs := make(map[string]map[string]*labelPair)
+		err = forEachSeries(block, func(lset promTsdbLabels.Labels, chks []chunks.Meta) {
+			if !no_bar {
+				bar.Incr()
+			}
+			var window []chunks.Meta
+			for _, c := range chks {
+				if c.MaxTime >= windowStart {
+					window = append(window, c)
+				}
+			}
+			if len(window) == 0 {
+				// churned before the head window, the head would not hold it
+				return
+			}
+
+			metric := lset.Get("__name__")
+			stat, ok := byMetric[metric]
+			if !ok {
+				stat = &memoryStat{Metric: metric}
+				byMetric[metric] = stat
+			}
+
+			stat.Series++
+			samples := float64(chunkSamples(window)) * scale
+			stat.Chunks += int(float64(chunkBytes(window))*scale) + int(math.Ceil(samples/samplesPerChunk))*memChunkBytes
+
+			for _, l := range lset {
+				size := labelBytes + len(l.Name) + len(l.Value)
+				stat.Labels += size
+				stat.Postings += postingBytes
+
+				label, ok := byLabel[l.Name]
+				if !ok {
+					label = &labelMemoryStat{Label: l.Name}
+					byLabel[l.Name] = label
+				}
+				label.Series++
+				label.Bytes += size + postingBytes
+
+				values, ok := pairs[l.Name]
+				if !ok {
+					values = make(map[string]*labelPair)
+					pairs[l.Name] = values
+				}
+				if pair, ok := values[l.Value]; !ok {
+					values[l.Value] = &labelPair{metric: metric}
+					label.Values++
+					label.Bytes += termBytes
+				} else if pair.metric != metric {
+					pair.shared = true
+				}
+			}
+		})
+		uiprogress.Stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading block failed: %s", err)
+			os.Exit(1)
+		}
+
+		var head int
+		for _, values := range pairs {
+			for _, pair := range values {
+				head += termBytes
+				if !pair.shared {
+					byMetric[pair.metric].Terms += termBytes
+				}
+			}
+		}
+
+		var stat []*memoryStat
+		for _, s := range byMetric {
+			stat = append(stat, s)
+			head += s.Total() - s.Terms
+		}
+		sort.Slice(stat, func(i, j int) bool {
+			if stat[i].Total() != stat[j].Total() {
+				return stat[i].Total() > stat[j].Total()
+			}
+			return stat[i].Metric < stat[j].Metric
+		})
+
+		var labels []*labelMemoryStat
+		for _, l := range byLabel {
+			if l.Label != "__name__" {
+				labels = append(labels, l)
+			}
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			if labels[i].Bytes != labels[j].Bytes {
+				return labels[i].Bytes > labels[j].Bytes
+			}
+			return labels[i].Label < labels[j].Label
+		})
+
+		if top < len(stat) {
+			stat = stat[:top]
+		}
+		if top < len(labels) {
+			labels = labels[:top]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "METRIC\tSERIES\tSTRUCTS\tLABELS\tPOSTINGS\tCHUNKS\tTERMS\tTOTAL")
+		p := message.NewPrinter(language.English)
+
+		for _, s := range stat {
+			fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				s.Metric,
+				p.Sprint(s.Series),
+				formatBytes(s.SeriesBytes()),
+				formatBytes(s.Labels),
+				formatBytes(s.Postings),
+				formatBytes(s.Chunks),
+				formatBytes(s.Terms),
+				formatBytes(s.Total()),
+			)
+		}
+
+		if len(labels) > 0 {
+			fmt.Fprintln(w, "\nLABEL\tVALUES\tSERIES\tRAM")
+			for _, l := range labels {
+				fmt.Fprintf(w, "%s\t%v\t%v\t%s\n",
+					l.Label,
+					p.Sprint(l.Values),
+					p.Sprint(l.Series),
+					formatBytes(l.Bytes),
+				)
+			}
+		}
+		w.Flush()
+
+		if len(stat) > 0 {
+			fmt.Printf("\nDropping %s saves ~%s of RAM, %.0f%% of the estimated %s head.\n",
+				stat[0].Metric, formatBytes(stat[0].Total()), percent(stat[0].Total(), head), formatBytes(head))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(memoryCmd)
+	memoryCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
+	memoryCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	memoryCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -16,12 +16,15 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"text/tabwriter"
 
 	"github.com/laszlocph/tsdbinfo/pkg/common"
 	promTsdb "github.com/prometheus/tsdb"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
 	"github.com/spf13/cobra"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
@@ -38,6 +41,10 @@ Digs deep on a given metric. It's best used to understand what labels you store
 
 Remember that every unique combination of key-value label pairs represents a new time series, which can dramatically increase the amount of data stored. Do not use labels to store dimensions with high cardinality (many different label values), such as user IDs, email addresses, or other unbounded sets of values.
 
+With --group-by the samples, series and labels are produced per value of the given labels, like a tenant label.
+
+With --head it inspects the head, built in memory from the WAL, instead of a block. See the metrics command.
+
 Example usage:
 
 	➜  tsdbinfo metric --storage.tsdb.path.copy=/my/prometheus/path/data --block=01CZWK46GK8BVHQCRNNS763NS3 --metric=http_server_requests_total
@@ -87,59 +94,103 @@ Example usage:
 			os.Exit(1)
 		}
 
-		if blockId == "" {
-			fmt.Fprintln(os.Stderr, "error: set --block")
+		if blockId == "" && !useHead {
+			fmt.Fprintln(os.Stderr, "error: set --block or --head")
 			os.Exit(2)
 		}
 
-		db, err := common.Open(storagePath, noPromLogs)
-		if err != nil {
-			fmt.Printf("opening storage failed: %s", err)
+		if blockId != "" && useHead {
+			fmt.Fprintln(os.Stderr, "error: --block and --head can't be used together")
+			os.Exit(2)
 		}
 
-		var block *promTsdb.Block
-		for _, b := range db.Blocks() {
-			if b.Meta().ULID.String() == blockId {
-				block = b
-				break
+		var block promTsdb.BlockReader
+		if useHead {
+			head, dropped, corruption, err := loadHead(filepath.Join(storagePath, "wal"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading the WAL failed: %s", err)
+				os.Exit(1)
+			}
+			if corruption != nil {
+				fmt.Fprintf(os.Stderr, "warning: the WAL is corrupted, the head only has the records before it: %s\n", corruption)
+			}
+			if dropped > 0 {
+				fmt.Fprintf(os.Stderr, "warning: %d samples of the WAL were dropped, of unknown series, out of order or out of the head range\n", dropped)
+			}
+			block = head
+		} else {
+			db, err := common.Open(storagePath, noPromLogs)
+			if err != nil {
+				fmt.Printf("opening storage failed: %s", err)
 			}
-		}
 
-		if block == nil {
-			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
-			os.Exit(2)
+			for _, b := range db.Blocks() {
+				if b.Meta().ULID.String() == blockId {
+					block = b
+					break
+				}
+			}
+
+			if block == nil {
+				fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+				os.Exit(2)
+			}
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
 		p := message.NewPrinter(language.English)
 
-		stat := numSamples(metric, db, block, false)
+		if len(groupBy) > 0 {
+			stats := numSamplesByGroup(metric, block, groupBy)
+			sort.Slice(stats, func(i, j int) bool {
+				return stats[i].Samples > stats[j].Samples
+			})
+
+			fmt.Fprintf(w, "%s\t%v\n", "Metric", p.Sprint(metric))
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%v\n", "Group", groupString(groupBy, stat.Group))
+				fmt.Fprintf(w, "%s\t%v\n", "Samples", p.Sprint(stat.Samples))
+				fmt.Fprintf(w, "%s\t%v\n", "TimeSeries", p.Sprint(stat.Series))
+				printLabels(w, p, block, groupMatchers(groupBy, stat.Group)...)
+			}
+			w.Flush()
+			return
+		}
+
+		stat := numSamples(metric, block, false)
 
 		fmt.Fprintf(w, "%s\t%v\n", "Metric", p.Sprint(stat.Metric))
 		fmt.Fprintf(w, "%s\t%v\n", "Samples", p.Sprint(stat.Samples))
 		fmt.Fprintf(w, "%s\t%v\n", "TimeSeries", p.Sprint(stat.Series))
-
-		lstats := labelStats(metric, block)
-		sort.Slice(lstats, func(i, j int) bool {
-			return lstats[i].Occurrences > lstats[j].Occurrences
-		})
-		for _, s := range lstats {
-			fmt.Fprintf(w, "Label\t%s\t%v\n", s.Label, p.Sprint(s.Occurrences))
-		}
-
-		labelStats := rawLabelStats(metric, block)
-		for label, values := range labelStats {
-			for v := range values {
-				fmt.Fprintf(w, "LabelValue\t%s\t%v\n", label, v)
-			}
-		}
+		printLabels(w, p, block)
 
 		w.Flush()
 	},
 }
 
+// printLabels lists the label cardinalities and the label values of the
+// metric's series that match the matchers.
+func printLabels(w io.Writer, p *message.Printer, block promTsdb.BlockReader, ms ...promTsdbLabels.Matcher) {
+	lstats := labelStats(metric, block, ms...)
+	sort.Slice(lstats, func(i, j int) bool {
+		return lstats[i].Occurrences > lstats[j].Occurrences
+	})
+	for _, s := range lstats {
+		fmt.Fprintf(w, "Label\t%s\t%v\n", s.Label, p.Sprint(s.Occurrences))
+	}
+
+	labelStats := rawLabelStats(metric, block, ms...)
+	for label, values := range labelStats {
+		for v := range values {
+			fmt.Fprintf(w, "LabelValue\t%s\t%v\n", label, v)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(metricCmd)
 	metricCmd.PersistentFlags().StringVar(&blockId, "block", "", "verbose output")
 	metricCmd.PersistentFlags().StringVar(&metric, "metric", "", "verbose output")
+	metricCmd.PersistentFlags().BoolVar(&useHead, "head", false, "Inspects the head, built from the WAL, instead of a block.")
+	metricCmd.PersistentFlags().StringSliceVar(&groupBy, "group-by", nil, "Slices the results by the values of these labels, like a tenant label.")
 }
@@ -16,7 +16,9 @@ package cmd
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -35,9 +37,13 @@ var blockId string
 var top int
 var top_labels int
 var no_bar bool
+var families bool
+var groupBy []string
+var useHead bool
 
 type metricStat struct {
 	Metric  string
+	Group   []string
 	Series  int
 	Samples int
 }
@@ -61,11 +67,11 @@ func metrics(indexReader promTsdb.IndexReader) []string {
 	return metrics
 }
 
-func numSamples(metric string, db *promTsdb.DB, block *promTsdb.Block, debug bool) metricStat {
+func numSamples(metric string, block promTsdb.BlockReader, debug bool) metricStat {
 	var totalSamples int
 	var totalTimeseries int
-	meta := block.Meta()
-	querier, _ := db.Querier(meta.MinTime, meta.MaxTime)
+	querier, _ := promTsdb.NewBlockQuerier(block, math.MinInt64, math.MaxInt64)
+	defer querier.Close()
 	seriesSet, err := querier.Select(promTsdbLabels.NewEqualMatcher("__name__", metric))
 	if err != nil {
 		fmt.Println(err)
@@ -85,12 +91,63 @@ func numSamples(metric string, db *promTsdb.DB, block *promTsdb.Block, debug boo
 		}
 	}
 
-	return metricStat{metric, totalTimeseries, totalSamples}
+	return metricStat{Metric: metric, Series: totalTimeseries, Samples: totalSamples}
 }
 
-func rawLabelStats(metric string, block *promTsdb.Block) map[string]map[string]bool {
+// numSamplesByGroup is numSamples sliced by the values of the groupBy labels.
+func numSamplesByGroup(metric string, block promTsdb.BlockReader, groupBy []string) []metricStat {
+	querier, _ := promTsdb.NewBlockQuerier(block, math.MinInt64, math.MaxInt64)
+	defer querier.Close()
+	seriesSet, err := querier.Select(promTsdbLabels.NewEqualMatcher("__name__", metric))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	var stat []metricStat
+	groups := make(map[string]int)
+	for seriesSet.Next() {
+		series := seriesSet.At()
+		group := groupValues(series.Labels(), groupBy)
+		key := strings.Join(group, "\xff")
+		i, ok := groups[key]
+		if !ok {
+			i = len(stat)
+			groups[key] = i
+			stat = append(stat, metricStat{Metric: metric, Group: group})
+		}
+
+		stat[i].Series++
+		it := series.Iterator()
+		for it.Next() {
+			stat[i].Samples++
+		}
+	}
+
+	return stat
+}
+
+// groupMatchers selects the series of a group.
+func groupMatchers(groupBy []string, group []string) []promTsdbLabels.Matcher {
+	var ms []promTsdbLabels.Matcher
+	for i, label := range groupBy {
+		ms = append(ms, promTsdbLabels.NewEqualMatcher(label, group[i]))
+	}
+	return ms
+}
+
+func groupString(groupBy []string, group []string) string {
+	var pairs []string
+	for i, label := range groupBy {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", label, group[i]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func rawLabelStats(metric string, block promTsdb.BlockReader, ms ...promTsdbLabels.Matcher) map[string]map[string]bool {
 	indexReader, _ := block.Index()
-	p, _ := promTsdb.PostingsForMatchers(indexReader, promTsdbLabels.NewEqualMatcher("__name__", metric))
+	ms = append([]promTsdbLabels.Matcher{promTsdbLabels.NewEqualMatcher("__name__", metric)}, ms...)
+	p, _ := promTsdb.PostingsForMatchers(indexReader, ms...)
 
 	var lset promTsdbLabels.Labels
 	var chks []chunks.Meta
@@ -111,8 +168,8 @@ func rawLabelStats(metric string, block *promTsdb.Block) map[string]map[string]b
 	return labelStats
 }
 
-func labelStats(metric string, block *promTsdb.Block) []labelStat {
-	labelStats := rawLabelStats(metric, block)
+func labelStats(metric string, block promTsdb.BlockReader, ms ...promTsdbLabels.Matcher) []labelStat {
+	labelStats := rawLabelStats(metric, block, ms...)
 
 	var stat []labelStat
 	for label, values := range labelStats {
@@ -129,6 +186,17 @@ var metricsCmd = &cobra.Command{
 	Long: `
 Identifies the largest metrics in a given block. You can get block IDs with the "tsdb blocks" command.
 
+With --families the foo_bucket, foo_sum, foo_count and foo{quantile=...} series are grouped into metric families. The type of the
+family (histogram, summary, counter, gauge) is guessed from the suffixes and the label shape, and the family becomes the unit of
+the ranking, followed by the breakdown of its members.
+
+With --group-by the results are produced per value of the given labels, like a tenant label, with totals and the top metrics
+inside each group.
+
+With --head it inspects the head instead of a block: the newest data that is not compacted into a block yet. The head is built
+in memory from the WAL and the checkpoint under the TSDB path, the WAL is only read. During a cardinality explosion this is where
+the new series are, the next block is cut up to two hours later.
+
 NOTE: It does a sequencial scan on the given block so it may take a long time
 
 Example usage:
@@ -146,27 +214,52 @@ Example usage:
 			os.Exit(1)
 		}
 
-		if blockId == "" {
-			fmt.Fprintln(os.Stderr, "error: set --block")
+		if blockId == "" && !useHead {
+			fmt.Fprintln(os.Stderr, "error: set --block or --head")
 			os.Exit(2)
 		}
 
-		db, err := common.Open(storagePath, noPromLogs)
-		if err != nil {
-			fmt.Printf("opening storage failed: %s", err)
+		if blockId != "" && useHead {
+			fmt.Fprintln(os.Stderr, "error: --block and --head can't be used together")
+			os.Exit(2)
 		}
 
-		var block *promTsdb.Block
-		for _, b := range db.Blocks() {
-			if b.Meta().ULID.String() == blockId {
-				block = b
-				break
-			}
+		if families && len(groupBy) > 0 {
+			fmt.Fprintln(os.Stderr, "error: --families and --group-by can't be used together")
+			os.Exit(2)
 		}
 
-		if block == nil {
-			fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
-			os.Exit(2)
+		var block promTsdb.BlockReader
+		if useHead {
+			head, dropped, corruption, err := loadHead(filepath.Join(storagePath, "wal"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading the WAL failed: %s", err)
+				os.Exit(1)
+			}
+			if corruption != nil {
+				fmt.Fprintf(os.Stderr, "warning: the WAL is corrupted, the head only has the records before it: %s\n", corruption)
+			}
+			if dropped > 0 {
+				fmt.Fprintf(os.Stderr, "warning: %d samples of the WAL were dropped, of unknown series, out of order or out of the head range\n", dropped)
+			}
+			block = head
+		} else {
+			db, err := common.Open(storagePath, noPromLogs)
+			if err != nil {
+				fmt.Printf("opening storage failed: %s", err)
+			}
+
+			for _, b := range db.Blocks() {
+				if b.Meta().ULID.String() == blockId {
+					block = b
+					break
+				}
+			}
+
+			if block == nil {
+				fmt.Fprintf(os.Stderr, "error: can't find block with id %s", blockId)
+				os.Exit(2)
+			}
 		}
 
 		indexReader, _ := block.Index()
@@ -185,7 +278,11 @@ Example usage:
 			if !no_bar {
 				bar.Incr()
 			}
-			stat = append(stat, numSamples(metric, db, block, false))
+			if len(groupBy) > 0 {
+				stat = append(stat, numSamplesByGroup(metric, block, groupBy)...)
+			} else {
+				stat = append(stat, numSamples(metric, block, false))
+			}
 		}
 
 		uiprogress.Stop()
@@ -195,6 +292,16 @@ Example usage:
 			return stat[i].Samples > stat[j].Samples
 		})
 
+		if families {
+			printFamilies(stat, block)
+			return
+		}
+
+		if len(groupBy) > 0 {
+			printGroups(stat, block)
+			return
+		}
+
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
 		fmt.Fprintln(w, "METRIC\tSAMPLES\tSERIES\tLABELS")
 		p := message.NewPrinter(language.English)
@@ -227,10 +334,137 @@ Example usage:
 	},
 }
 
+// printGroups lists the groups with most samples, each followed by its top
+// metrics.
+func printGroups(stat []metricStat, block promTsdb.BlockReader) {
+	var groups [][]metricStat
+	byGroup := make(map[string]int)
+	for _, s := range stat {
+		key := strings.Join(s.Group, "\xff")
+		i, ok := byGroup[key]
+		if !ok {
+			i = len(groups)
+			byGroup[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], s)
+	}
+
+	totals := make([]metricStat, len(groups))
+	for i, group := range groups {
+		totals[i].Group = group[0].Group
+		for _, s := range group {
+			totals[i].Samples += s.Samples
+			totals[i].Series += s.Series
+		}
+	}
+	sort.Sort(byTotalSamples{groups, totals})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+	p := message.NewPrinter(language.English)
+
+	fmt.Fprintln(w, "GROUP\tSAMPLES\tSERIES\tLABELS")
+	for i, group := range groups {
+		fmt.Fprintf(w, "%s\t%v\t%v\t\n", groupString(groupBy, totals[i].Group), p.Sprint(totals[i].Samples), p.Sprint(totals[i].Series))
+
+		if top < len(group) {
+			group = group[:top]
+		}
+		for _, values := range group {
+			var statStrings []string
+			lstats := labelStats(values.Metric, block, groupMatchers(groupBy, values.Group)...)
+			sort.Slice(lstats, func(i, j int) bool {
+				return lstats[i].Occurrences > lstats[j].Occurrences
+			})
+			if top_labels < len(lstats) {
+				lstats = lstats[:top_labels]
+			}
+			for _, s := range lstats {
+				statStrings = append(statStrings, p.Sprintf("%s: %d", s.Label, s.Occurrences))
+			}
+
+			fmt.Fprintf(w, "  %s\t%v\t%v\t%s\n",
+				values.Metric,
+				p.Sprint(values.Samples),
+				p.Sprint(values.Series),
+				strings.Join(statStrings, ", "),
+			)
+		}
+	}
+	w.Flush()
+}
+
+// byTotalSamples sorts the groups of metric stats by their total samples.
+type byTotalSamples struct {
+	groups [][]metricStat
+	totals []metricStat
+}
+
+func (b byTotalSamples) Len() int { return len(b.groups) }
+func (b byTotalSamples) Less(i, j int) bool {
+	return b.totals[i].Samples > b.totals[j].Samples
+}
+func (b byTotalSamples) Swap(i, j int) {
+	b.groups[i], b.groups[j] = b.groups[j], b.groups[i]
+	b.totals[i], b.totals[j] = b.totals[j], b.totals[i]
+}
+
+// printFamilies lists the metric families with most samples, each followed
+// by the breakdown of its members.
+func printFamilies(stat []metricStat, block promTsdb.BlockReader) {
+	families := metricFamilies(stat, block)
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].Samples > families[j].Samples
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "FAMILY\tTYPE\tSAMPLES\tSERIES\tLABELS")
+	p := message.NewPrinter(language.English)
+
+	if top < len(families) {
+		families = families[:top]
+	}
+	for _, family := range families {
+		var statStrings []string
+		lstats := familyLabelStats(family, block)
+		sort.Slice(lstats, func(i, j int) bool {
+			return lstats[i].Occurrences > lstats[j].Occurrences
+		})
+		if top_labels < len(lstats) {
+			lstats = lstats[:top_labels]
+		}
+		for _, s := range lstats {
+			statStrings = append(statStrings, p.Sprintf("%s: %d", s.Label, s.Occurrences))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\n",
+			family.Name,
+			family.Type,
+			p.Sprint(family.Samples),
+			p.Sprint(family.Series),
+			strings.Join(statStrings, ", "),
+		)
+		if len(family.Members) == 1 && family.Members[0].Metric == family.Name {
+			continue
+		}
+		for _, member := range family.Members {
+			fmt.Fprintf(w, "  %s\t\t%v\t%v\t\n",
+				member.Metric,
+				p.Sprint(member.Samples),
+				p.Sprint(member.Series),
+			)
+		}
+	}
+	w.Flush()
+}
+
 func init() {
 	rootCmd.AddCommand(metricsCmd)
 	metricsCmd.PersistentFlags().StringVar(&blockId, "block", "", "The ID of the TSDB block to inspect.")
 	metricsCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
 	metricsCmd.PersistentFlags().IntVar(&top_labels, "top-labels", 5, "Number of labels to display. Default: 5")
 	metricsCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+	metricsCmd.PersistentFlags().StringSliceVar(&groupBy, "group-by", nil, "Slices the results by the values of these labels, like a tenant label.")
+	metricsCmd.PersistentFlags().BoolVar(&useHead, "head", false, "Inspects the head, built from the WAL, instead of a block.")
+	metricsCmd.PersistentFlags().BoolVar(&families, "families", false, "Groups the metrics into histogram, summary, counter and gauge families and ranks the families.")
 }
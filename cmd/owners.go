@@ -0,0 +1,198 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/laszlocph/tsdbinfo/pkg/owners"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var mappingFile string
+var output string
+
+type teamCost struct {
+	Team           string           `json:"team"`
+	Series         int              `json:"series"`
+	Samples        int              `json:"samples"`
+	Bytes          int              `json:"bytes"`
+	SamplesPercent float64          `json:"samplesPercent"`
+	BytesPercent   float64          `json:"bytesPercent"`
+	TopMetrics     []teamCostMetric `json:"topMetrics"`
+}
+
+type teamCostMetric struct {
+	Metric  string `json:"metric"`
+	Samples int    `json:"samples"`
+}
+
+// ownersCmd represents the owners command
+var ownersCmd = &cobra.Command{
+	Use:   "owners",
+	Short: "To attribute series, samples and bytes to the teams owning them",
+	Long: `
+Attributes the series, samples and bytes of the selected blocks to teams, for chargeback on a shared Prometheus.
+Select blocks with --block, or leave it empty to use all of them.
+
+The mapping file assigns ownership by label matchers and metric name prefixes. Owners are matched in order, the first
+matching owner gets the series. Label matchers are anchored regular expressions. Series no owner matches go to the default team.
+
+	default: platform
+	owners:
+	- team: payments
+	  match:
+	    kubernetes_namespace: payments|checkout
+	- team: data
+	  match:
+	    job: kafka-.*
+	  metric_prefix: [kafka_, zookeeper_]
+
+NOTE: It does a sequencial scan on the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo owners --storage.tsdb.path.copy=/my/prometheus/path/data --mapping=owners.yml --no-bar
+  TEAM        SERIES       SAMPLES          BYTES            SAMPLES %    BYTES %    TOP METRICS
+  search      81,209       1,298,310,282    170,337,893      62%          61%        solr_metrics_core_errors_total (13%), solr_metrics_core_time_seconds_total (13%), solr_metrics_core_timeouts_total (13%)
+  platform    2,989,339    790,107,611      105,262,099      38%          39%        node_cpu_seconds_total (12%), node_interrupts_total (10%), kube_pod_info (4%)
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if mappingFile == "" {
+			fmt.Fprintln(os.Stderr, "error: set --mapping")
+			os.Exit(2)
+		}
+
+		if output != "table" && output != "json" && output != "csv" {
+			fmt.Fprintln(os.Stderr, "error: --output must be one of table, json or csv")
+			os.Exit(2)
+		}
+
+		mapping, err := owners.Load(mappingFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		teams, err := collectCosts(blocks, func(lset promTsdbLabels.Labels) []string {
+			return []string{mapping.Owner(lset)}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading blocks failed: %s", err)
+			os.Exit(1)
+		}
+
+		stat, totalSamples := teams.sorted()
+		var totalBytes int
+		for _, s := range stat {
+			totalBytes += s.Bytes
+		}
+
+		var report []teamCost
+		for _, s := range stat {
+			team := teamCost{
+				Team:           s.Group[0],
+				Series:         s.Series(),
+				Samples:        s.Samples,
+				Bytes:          s.Bytes,
+				SamplesPercent: percent(s.Samples, totalSamples),
+				BytesPercent:   percent(s.Bytes, totalBytes),
+			}
+			for _, metric := range s.metricsBySamples(topMetrics) {
+				team.TopMetrics = append(team.TopMetrics, teamCostMetric{metric, s.metrics[metric]})
+			}
+			report = append(report, team)
+		}
+
+		switch output {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{"team", "series", "samples", "bytes", "samples_percent", "bytes_percent", "top_metrics"})
+			for _, team := range report {
+				var metrics []string
+				for _, m := range team.TopMetrics {
+					metrics = append(metrics, m.Metric)
+				}
+				w.Write([]string{
+					team.Team,
+					strconv.Itoa(team.Series),
+					strconv.Itoa(team.Samples),
+					strconv.Itoa(team.Bytes),
+					strconv.FormatFloat(team.SamplesPercent, 'f', 2, 64),
+					strconv.FormatFloat(team.BytesPercent, 'f', 2, 64),
+					strings.Join(metrics, " "),
+				})
+			}
+			w.Flush()
+		default:
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "TEAM\tSERIES\tSAMPLES\tBYTES\tSAMPLES %\tBYTES %\tTOP METRICS")
+			p := message.NewPrinter(language.English)
+			for i, team := range report {
+				fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%.0f%%\t%.0f%%\t%s\n",
+					team.Team,
+					p.Sprint(team.Series),
+					p.Sprint(team.Samples),
+					p.Sprint(team.Bytes),
+					team.SamplesPercent,
+					team.BytesPercent,
+					stat[i].topMetrics(topMetrics, p),
+				)
+			}
+			w.Flush()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ownersCmd)
+	ownersCmd.PersistentFlags().StringVar(&mappingFile, "mapping", "", "The YAML file that maps series to teams.")
+	ownersCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	ownersCmd.PersistentFlags().StringVar(&output, "output", "table", "The output format: table, json or csv.")
+	ownersCmd.PersistentFlags().IntVar(&topMetrics, "top-metrics", 3, "Number of metrics to display for each team. Default: 3")
+	ownersCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -0,0 +1,283 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/common/model"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var retentionTime string
+var retentionSize string
+var diskFree string
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "To plan disk capacity for a retention",
+	Long: `
+Projects the disk usage of the given --retention.time and --retention.size from the size and time range of the blocks,
+lists the blocks that the retention would delete, and estimates how long until the disk is full.
+
+The ingestion rate and the bytes per sample are measured on the blocks. The retention rules are the ones of Prometheus:
+a block is deleted when it ends more than --retention.time before the newest block ends, or when the blocks newer than
+it already take --retention.size. The head and the WAL are not counted.
+
+The free space is the one of the filesystem of --storage.tsdb.path.copy. Use --disk.free to plan for the disk of the
+Prometheus server instead.
+
+Example usage:
+
+  ➜  tsdbinfo plan --storage.tsdb.path.copy=/my/prometheus/path/data --retention.time=30d --disk.free=200GB
+  Blocks:              14 blocks, 41.2 GiB, 20d 1h
+  Ingestion:           36,112 samples/s, 1.31 bytes/sample, 3.8 GiB/day
+  Projected usage:     114.0 GiB with retention.time=30d
+  Disk:                200.0 GiB free, the usage levels off with 127.2 GiB to spare
+
+  Deleted by the retention:
+  ID                            FROM                         UNTIL                        SIZE       REASON
+  01CZWK46GK8BVHQCRNNS763NS3    2018-12-22T13:00:00+01:00    2018-12-29T07:00:00+01:00    4.1 GiB    retention.time
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		retention, err := model.ParseDuration(retentionTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --retention.time: %s", err)
+			os.Exit(2)
+		}
+
+		var maxBytes int64
+		if retentionSize != "" {
+			maxBytes, err = parseBytes(retentionSize)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --retention.size: %s", err)
+				os.Exit(2)
+			}
+		}
+
+		var free int64
+		if diskFree != "" {
+			free, err = parseBytes(diskFree)
+		} else {
+			free, err = common.DiskFree(storagePath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: can't tell the free disk space, set --disk.free: %s", err)
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		// newest first, like the retention of Prometheus walks them
+		blocks := append([]*promTsdb.Block(nil), db.Blocks()...)
+		sort.Slice(blocks, func(i, j int) bool {
+			return blocks[i].Meta().MaxTime > blocks[j].Meta().MaxTime
+		})
+		if len(blocks) == 0 {
+			fmt.Fprintln(os.Stderr, "error: there are no blocks to plan with")
+			os.Exit(1)
+		}
+
+		var size, samples int64
+		for _, block := range blocks {
+			size += block.Size()
+			samples += int64(block.Meta().Stats.NumSamples)
+		}
+		span := coveredTime(blocks)
+		if samples == 0 || span == 0 {
+			fmt.Fprintln(os.Stderr, "error: the blocks have no samples to measure the ingestion rate")
+			os.Exit(1)
+		}
+
+		samplesPerSecond := float64(samples) / (float64(span) / 1000)
+		bytesPerSample := float64(size) / float64(samples)
+		bytesPerDay := int64(samplesPerSecond * bytesPerSample * 24 * 60 * 60)
+
+		projected := int64(float64(time.Duration(retention)/time.Second) * samplesPerSecond * bytesPerSample)
+		limitedBy := "retention.time=" + retentionTime
+		if maxBytes > 0 && (retention == 0 || maxBytes < projected) {
+			projected = maxBytes
+			limitedBy = "retention.size=" + retentionSize
+		}
+
+		p := message.NewPrinter(language.English)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintf(w, "Blocks:\t%d blocks, %s, %s\n", len(blocks), formatBytes(int(size)), model.Duration(time.Duration(span)*time.Millisecond))
+		fmt.Fprintf(w, "Ingestion:\t%s samples/s, %.2f bytes/sample, %s/day\n", p.Sprintf("%.0f", samplesPerSecond), bytesPerSample, formatBytes(int(bytesPerDay)))
+		if retention == 0 && maxBytes == 0 {
+			fmt.Fprintf(w, "Projected usage:\tgrows without a retention\n")
+		} else {
+			fmt.Fprintf(w, "Projected usage:\t%s with %s\n", formatBytes(int(projected)), limitedBy)
+		}
+
+		// the disk fills up if the usage grows more than the free space before the retention levels it off
+		growth := projected - size
+		switch {
+		case (retention != 0 || maxBytes != 0) && growth <= free:
+			fmt.Fprintf(w, "Disk:\t%s free, the usage levels off with %s to spare\n", formatBytes(int(free)), formatBytes(int(free-maxInt64(growth, 0))))
+		case bytesPerDay > 0:
+			full := time.Duration(float64(free) / float64(bytesPerDay) * float64(24*time.Hour))
+			if full > time.Hour {
+				full = full.Truncate(time.Hour)
+			} else {
+				full = full.Truncate(time.Minute)
+			}
+			fmt.Fprintf(w, "Disk:\t%s free, full in %s at the current growth\n", formatBytes(int(free)), model.Duration(full))
+		}
+		w.Flush()
+
+		deleted := retained(blocks, retention, maxBytes)
+		if len(deleted) == 0 {
+			fmt.Println("\nThe retention deletes no block.")
+			return
+		}
+
+		fmt.Println("\nDeleted by the retention:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tFROM\tUNTIL\tSIZE\tREASON")
+		for _, block := range blocks {
+			reason, ok := deleted[block]
+			if !ok {
+				continue
+			}
+			meta := block.Meta()
+			fmt.Fprintf(w, "%s\t%v\t%v\t%s\t%s\n",
+				meta.ULID,
+				time.Unix(meta.MinTime/1000, 0).Format(time.RFC3339),
+				time.Unix(meta.MaxTime/1000, 0).Format(time.RFC3339),
+				formatBytes(int(block.Size())),
+				reason,
+			)
+		}
+		w.Flush()
+	},
+}
+
+// coveredTime returns the time the blocks cover, counting the time of
+// overlapping blocks once.
+func coveredTime(blocks []*promTsdb.Block) int64 {
+	metas := make([]promTsdb.BlockMeta, 0, len(blocks))
+	for _, block := range blocks {
+		metas = append(metas, block.Meta())
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+
+	var covered int64
+	end := int64(math.MinInt64)
+	for _, meta := range metas {
+		start := meta.MinTime
+		if start < end {
+			start = end
+		}
+		if meta.MaxTime > start {
+			covered += meta.MaxTime - start
+			end = meta.MaxTime
+		}
+	}
+	return covered
+}
+
+// retained returns the blocks the retention of Prometheus deletes, with the
+// retention setting that deletes them. The blocks must be sorted newest first.
+func retained(blocks []*promTsdb.Block, retention model.Duration, maxBytes int64) map[*promTsdb.Block]string {
+	deleted := make(map[*promTsdb.Block]string)
+
+	if retention > 0 {
+		for i, block := range blocks {
+			if i > 0 && blocks[0].Meta().MaxTime-block.Meta().MaxTime > int64(time.Duration(retention)/time.Millisecond) {
+				for _, b := range blocks[i:] {
+					deleted[b] = "retention.time"
+				}
+				break
+			}
+		}
+	}
+
+	if maxBytes > 0 {
+		var size int64
+		for i, block := range blocks {
+			size += block.Size()
+			if size > maxBytes {
+				for _, b := range blocks[i:] {
+					if _, ok := deleted[b]; !ok {
+						deleted[b] = "retention.size"
+					}
+				}
+				break
+			}
+		}
+	}
+
+	return deleted
+}
+
+// parseBytes parses sizes like 512MB the way Prometheus parses
+// --storage.tsdb.retention.size, with base 2 units.
+func parseBytes(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"EB", 1 << 60}, {"PB", 1 << 50}, {"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(s, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.size, nil
+		}
+	}
+	return 0, fmt.Errorf("%q has no unit, use one of B, KB, MB, GB, TB, PB or EB", s)
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.PersistentFlags().StringVar(&retentionTime, "retention.time", "15d", "The retention time to plan for, like --storage.tsdb.retention.time of Prometheus.")
+	planCmd.PersistentFlags().StringVar(&retentionSize, "retention.size", "", "The retention size to plan for, like --storage.tsdb.retention.size of Prometheus. Default: no size limit")
+	planCmd.PersistentFlags().StringVar(&diskFree, "disk.free", "", "The free space of the disk, like 200GB. Default: the free space of the filesystem of --storage.tsdb.path.copy")
+}
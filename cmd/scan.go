@@ -0,0 +1,151 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+)
+
+// sample is a single decoded timestamp/value pair of a series.
+type sample struct {
+	T int64
+	V float64
+}
+
+func findBlock(db *promTsdb.DB, id string) *promTsdb.Block {
+	for _, b := range db.Blocks() {
+		if b.Meta().ULID.String() == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// selectBlocks returns the blocks with the given ULIDs, or all the blocks when
+// no ULID is given.
+func selectBlocks(db *promTsdb.DB, ids []string) ([]*promTsdb.Block, error) {
+	if len(ids) == 0 {
+		return db.Blocks(), nil
+	}
+
+	var blocks []*promTsdb.Block
+	for _, id := range ids {
+		block := findBlock(db, id)
+		if block == nil {
+			return nil, fmt.Errorf("can't find block with id %s", id)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// forEachSeries calls fn for every series in the block that matches all the
+// given matchers, or for every series when no matcher is given. The chunks
+// handed to fn have their data populated.
+func forEachSeries(b promTsdb.BlockReader, fn func(lset promTsdbLabels.Labels, chks []chunks.Meta), ms ...promTsdbLabels.Matcher) error {
+	indexReader, err := b.Index()
+	if err != nil {
+		return err
+	}
+	defer indexReader.Close()
+
+	chunkReader, err := b.Chunks()
+	if err != nil {
+		return err
+	}
+	defer chunkReader.Close()
+
+	var p index.Postings
+	if len(ms) == 0 {
+		p, err = indexReader.Postings(index.AllPostingsKey())
+	} else {
+		p, err = promTsdb.PostingsForMatchers(indexReader, ms...)
+	}
+	if err != nil {
+		return err
+	}
+
+	var lset promTsdbLabels.Labels
+	var chks []chunks.Meta
+	for p.Next() {
+		if err := indexReader.Series(p.At(), &lset, &chks); err != nil {
+			return err
+		}
+		for i := range chks {
+			chks[i].Chunk, err = chunkReader.Chunk(chks[i].Ref)
+			if err != nil {
+				return err
+			}
+		}
+		fn(lset, chks)
+	}
+
+	return p.Err()
+}
+
+// chunkBytes returns the encoded size of the chunks.
+func chunkBytes(chks []chunks.Meta) int {
+	var size int
+	for _, c := range chks {
+		size += len(c.Chunk.Bytes())
+	}
+	return size
+}
+
+// chunkSamples returns the number of samples stored in the chunks.
+func chunkSamples(chks []chunks.Meta) int {
+	var n int
+	for _, c := range chks {
+		n += c.Chunk.NumSamples()
+	}
+	return n
+}
+
+// decodeSamples decodes all the samples stored in the chunks. Stale markers
+// and other NaN values are skipped.
+func decodeSamples(chks []chunks.Meta) []sample {
+	var samples []sample
+	for _, c := range chks {
+		it := c.Chunk.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if math.IsNaN(v) {
+				continue
+			}
+			samples = append(samples, sample{t, v})
+		}
+	}
+	return samples
+}
+
+// formatBytes formats a size with binary units, like 1.5 GiB.
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
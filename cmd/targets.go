@@ -0,0 +1,188 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/laszlocph/tsdbinfo/pkg/promconfig"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var blockIds []string
+var targetLabels []string
+var topMetrics int
+var configFile string
+
+// targetsCmd represents the targets command
+var targetsCmd = &cobra.Command{
+	Use:   "targets",
+	Short: "To attribute series, samples and bytes to scrape targets",
+	Long: `
+Attributes the series, samples and bytes of the selected blocks to each job and instance, or to any --by label set,
+along with the top metrics of each target. Select blocks with --block, or leave it empty to use all of them.
+
+BYTES is the size of the encoded chunks, the index is not attributed.
+
+With --config.file it loads prometheus.yml, and summarizes the cost of each job next to its scrape_config: the
+scrape interval, the sample_limit and the number of metric_relabel_configs. The --by labels must include job.
+
+NOTE: It does a sequencial scan on the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo targets --storage.tsdb.path.copy=/my/prometheus/path/data --no-bar --top=3
+  JOB              INSTANCE            SERIES     SAMPLES          BYTES            SAMPLES %    TOP METRICS
+  solr             10.0.3.17:8983      81,209     1,298,310,282    170,337,893      40%          solr_metrics_core_errors_total (13%), solr_metrics_core_time_seconds_total (13%), solr_metrics_core_timeouts_total (13%)
+  node             10.0.3.17:9100      1,931      40,107,611       5,262,099        1%           node_cpu_seconds_total (12%), node_interrupts_total (10%), node_softnet_processed_total (4%)
+  kubernetes-pods  10.0.4.2:8080       582        5,365,975        704,016          0%           http_server_requests_total (100%)
+
+  ➜  tsdbinfo targets --storage.tsdb.path.copy=/my/prometheus/path/data --config.file=prometheus.yml --no-bar --top=3
+  ...
+
+  JOB                SERIES     SAMPLES          BYTES          INTERVAL    SAMPLE LIMIT    METRIC RELABELS    NOTES
+  solr               81,209     1,298,310,282    170,337,893    15s         -               0                  81,209 series with no sample_limit
+  node               1,931      40,107,611       5,262,099      30s         5,000           2 (1 drop)
+  kubernetes-pods    582        5,365,975        704,016        -           -               -                  not in the config
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if len(targetLabels) == 0 {
+			fmt.Fprintln(os.Stderr, "error: set --by")
+			os.Exit(2)
+		}
+
+		var config *promconfig.Config
+		jobIndex := -1
+		if configFile != "" {
+			for i, label := range targetLabels {
+				if label == "job" {
+					jobIndex = i
+				}
+			}
+			if jobIndex == -1 {
+				fmt.Fprintln(os.Stderr, "error: --config.file needs job in --by")
+				os.Exit(2)
+			}
+
+			var err error
+			config, err = promconfig.Load(configFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s", err)
+				os.Exit(2)
+			}
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		targets, err := collectCosts(blocks, func(lset promTsdbLabels.Labels) []string {
+			return groupValues(lset, targetLabels)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading blocks failed: %s", err)
+			os.Exit(1)
+		}
+
+		stat, total := targets.sorted()
+		if top < len(stat) {
+			stat = stat[:top]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintf(w, "%s\tSERIES\tSAMPLES\tBYTES\tSAMPLES %%\tTOP METRICS\n", strings.ToUpper(strings.Join(targetLabels, "\t")))
+		p := message.NewPrinter(language.English)
+
+		for _, s := range stat {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%.0f%%\t%s\n",
+				strings.Join(s.Group, "\t"),
+				p.Sprint(s.Series()),
+				p.Sprint(s.Samples),
+				p.Sprint(s.Bytes),
+				percent(s.Samples, total),
+				s.topMetrics(topMetrics, p),
+			)
+		}
+
+		if config != nil {
+			jobs, _ := targets.regroup(func(c *cost) []string {
+				return []string{c.Group[jobIndex]}
+			}).sorted()
+			if top < len(jobs) {
+				jobs = jobs[:top]
+			}
+
+			fmt.Fprintln(w, "\nJOB\tSERIES\tSAMPLES\tBYTES\tINTERVAL\tSAMPLE LIMIT\tMETRIC RELABELS\tNOTES")
+			for _, job := range jobs {
+				interval, sampleLimit, relabels, notes := "-", "-", "-", "not in the config"
+				if sc := config.Job(job.Group[0]); sc != nil {
+					interval = sc.ScrapeInterval.String()
+					relabels = p.Sprint(len(sc.MetricRelabelConfigs))
+					if drops := sc.Drops(); drops > 0 {
+						relabels = p.Sprintf("%d (%d drop)", len(sc.MetricRelabelConfigs), drops)
+					}
+					notes = ""
+					if sc.SampleLimit == 0 {
+						notes = p.Sprintf("%d series with no sample_limit", job.Series())
+					} else {
+						sampleLimit = p.Sprint(sc.SampleLimit)
+					}
+				}
+				fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%s\t%s\t%s\t%s\n",
+					job.Group[0],
+					p.Sprint(job.Series()),
+					p.Sprint(job.Samples),
+					p.Sprint(job.Bytes),
+					interval,
+					sampleLimit,
+					relabels,
+					notes,
+				)
+			}
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(targetsCmd)
+	targetsCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	targetsCmd.PersistentFlags().StringSliceVar(&targetLabels, "by", []string{"job", "instance"}, "The labels that identify a target.")
+	targetsCmd.PersistentFlags().StringVar(&configFile, "config.file", "", "Optional prometheus.yml to show the scrape config of each job.")
+	targetsCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	targetsCmd.PersistentFlags().IntVar(&topMetrics, "top-metrics", 3, "Number of metrics to display for each target. Default: 3")
+	targetsCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
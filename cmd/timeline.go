@@ -0,0 +1,169 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/prometheus/common/model"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var width int
+
+// sizeShades shade the bars from the smallest to the largest blocks.
+var sizeShades = []rune{'░', '▒', '▓', '█'}
+
+// timelineCmd represents the timeline command
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "To draw the blocks on a time axis",
+	Long: `
+Draws the blocks as bars on a shared time axis, oldest first. The shade of a bar is the size of the block compared to
+the largest one, from ░ to █. The last two rows mark the gaps with - and the overlaps with !, see blocks --check
+for the details.
+
+Example usage:
+
+  ➜  tsdbinfo timeline --storage.tsdb.path.copy=/my/prometheus/path/data --width=60
+  ID                            LEVEL    SIZE        2018-12-22T13:00:00+01:00 - 2019-01-17T01:00:00+01:00, 1 column is 10h12m
+  01CZWK46GK8BVHQCRNNS763NS3    5        4.1 GiB     |█████████████████                                           |
+  01D1EFWJ44G9WGN7AQ9398G2W2    3        8.4 KiB     |                                  ░░                        |
+  01D1F0R2XBMJ4RZ4MWA0M3M9K2    1        8.4 KiB     |                                   ░                        |
+  01D1EFWJRQ35VYNT2M4YYEJV3R    3        8.4 KiB     |                                                        ░░  |
+  gaps                                               |                 -----------------    ----------------------  |
+  overlaps                                           |                                   !                        |
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if width < 10 {
+			fmt.Fprintln(os.Stderr, "error: --width must be at least 10")
+			os.Exit(2)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks := append([]*promTsdb.Block(nil), db.Blocks()...)
+		if len(blocks) == 0 {
+			fmt.Println("There are no blocks.")
+			return
+		}
+		sort.SliceStable(blocks, func(i, j int) bool {
+			return blocks[i].Meta().MinTime < blocks[j].Meta().MinTime
+		})
+
+		from, until := blocks[0].Meta().MinTime, blocks[0].Meta().MaxTime
+		var largest int64
+		for _, block := range blocks {
+			if block.Meta().MaxTime > until {
+				until = block.Meta().MaxTime
+			}
+			if block.Size() > largest {
+				largest = block.Size()
+			}
+		}
+		// whole minutes per column, so the scale reads well
+		minute := int64(time.Minute / time.Millisecond)
+		step := (until - from + int64(width) - 1) / int64(width)
+		step = (step + minute - 1) / minute * minute
+		if step < minute {
+			step = minute
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintf(w, "ID\tLEVEL\tSIZE\t%s - %s, 1 column is %s\n",
+			time.Unix(from/1000, 0).Format(time.RFC3339),
+			time.Unix(until/1000, 0).Format(time.RFC3339),
+			model.Duration(time.Duration(step)*time.Millisecond),
+		)
+
+		for _, block := range blocks {
+			meta := block.Meta()
+			shade := sizeShades[0]
+			if largest > 0 {
+				shade = sizeShades[int(block.Size()*int64(len(sizeShades)-1)/largest)]
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t|%s|\n",
+				meta.ULID,
+				meta.Compaction.Level,
+				formatBytes(int(block.Size())),
+				timelineBar(from, step, meta.MinTime, meta.MaxTime, shade),
+			)
+		}
+
+		gaps := []rune(strings.Repeat(" ", width))
+		overlaps := []rune(strings.Repeat(" ", width))
+		for _, issue := range checkBlocks(blocks) {
+			switch issue.Kind {
+			case "gap":
+				mark(gaps, from, step, issue.MinTime, issue.MaxTime, '-')
+			case "overlap":
+				mark(overlaps, from, step, issue.MinTime, issue.MaxTime, '!')
+			}
+		}
+		fmt.Fprintf(w, "gaps\t\t\t|%s|\n", string(gaps))
+		fmt.Fprintf(w, "overlaps\t\t\t|%s|\n", string(overlaps))
+		w.Flush()
+	},
+}
+
+// timelineBar draws the time range on a row of width columns of step
+// milliseconds each, starting at from.
+func timelineBar(from, step, mint, maxt int64, shade rune) string {
+	row := []rune(strings.Repeat(" ", width))
+	mark(row, from, step, mint, maxt, shade)
+	return string(row)
+}
+
+// mark fills the columns of the row the time range falls in, rounded to the
+// nearest column so adjacent ranges don't share one. A range shorter than a
+// column still fills one, even at the end of the row.
+func mark(row []rune, from, step, mint, maxt int64, r rune) {
+	first := int((mint - from + step/2) / step)
+	if first > len(row)-1 {
+		first = len(row) - 1
+	}
+	if first < 0 {
+		first = 0
+	}
+	last := int((maxt - from + step/2) / step)
+	if last <= first {
+		last = first + 1
+	}
+	for i := first; i < last && i < len(row); i++ {
+		row[i] = r
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(timelineCmd)
+	timelineCmd.PersistentFlags().IntVar(&width, "width", 80, "The number of columns of the time axis.")
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMark(t *testing.T) {
+	const hour = int64(60 * 60 * 1000)
+	cases := []struct {
+		name       string
+		mint, maxt int64
+		want       string
+	}{
+		{
+			name: "whole axis",
+			mint: 0, maxt: 600 * hour,
+			want: strings.Repeat("#", 60),
+		},
+		{
+			name: "first column",
+			mint: 0, maxt: 10 * hour,
+			want: "#" + strings.Repeat(" ", 59),
+		},
+		{
+			name: "shorter than a column",
+			mint: 100 * hour, maxt: 102 * hour,
+			want: strings.Repeat(" ", 10) + "#" + strings.Repeat(" ", 49),
+		},
+		{
+			name: "shorter than a column at the end",
+			mint: 598 * hour, maxt: 600 * hour,
+			want: strings.Repeat(" ", 59) + "#",
+		},
+	}
+
+	for _, c := range cases {
+		row := []rune(strings.Repeat(" ", 60))
+		mark(row, 0, 10*hour, c.mint, c.maxt, '#')
+		if string(row) != c.want {
+			t.Errorf("%s: row is %q, want %q", c.name, string(row), c.want)
+		}
+	}
+}
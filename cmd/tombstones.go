@@ -0,0 +1,249 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// tombstoneStat is what the tombstones of a block mask, for a series or a
+// metric.
+type tombstoneStat struct {
+	Name        string
+	Series      int
+	Intervals   promTsdb.Intervals
+	Masked      int
+	Reclaimable int
+}
+
+// tombstonesCmd represents the tombstones command
+var tombstonesCmd = &cobra.Command{
+	Use:   "tombstones",
+	Short: "To list the deleted intervals of the blocks",
+	Long: `
+Lists what the tombstones of each block delete, from delete series API calls. Select blocks with --block, or leave it
+empty to use all of them. Use --series to list the deleted intervals of every series.
+
+The deleted samples are masked at query time but stay on disk until the block is compacted or the clean tombstones API
+rewrites it. MASKED is the number of those samples, RECLAIMABLE is an estimate of the chunk bytes the rewrite frees:
+all of them for fully deleted series, their share of the samples otherwise.
+
+Example usage:
+
+  ➜  tsdbinfo tombstones --storage.tsdb.path.copy=/my/prometheus/path/data --series
+  BLOCK                         METRIC                                  SERIES    MASKED       RECLAIMABLE
+  01D1EFWJRQ35VYNT2M4YYEJV3R    solr_metrics_core_time_seconds_total    4,229     1,048,213    1.3 MiB
+  01D1EFWJRQ35VYNT2M4YYEJV3R    up                                      1         120          312 B
+
+  BLOCK                         SERIES                                                               MASKED     RECLAIMABLE    INTERVALS
+  01D1EFWJRQ35VYNT2M4YYEJV3R    {__name__="up",instance="10.0.3.17:9100",job="node"}                 120        312 B          2019-01-16T08:00:00+01:00 - 2019-01-16T08:30:00+01:00
+
+  1 of 3 blocks have tombstones, a clean tombstones would reclaim ~1.3 MiB
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		p := message.NewPrinter(language.English)
+
+		var metricRows, seriesRows []string
+		var withTombstones, reclaimable int
+		for _, block := range blocks {
+			metrics, series, err := blockTombstones(block)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: reading block %s failed: %s", block.Meta().ULID, err)
+				os.Exit(1)
+			}
+			if len(series) == 0 {
+				continue
+			}
+			withTombstones++
+
+			for _, m := range metrics {
+				reclaimable += m.Reclaimable
+				metricRows = append(metricRows, p.Sprintf("%s\t%s\t%d\t%d\t%s",
+					block.Meta().ULID, m.Name, m.Series, m.Masked, formatBytes(m.Reclaimable)))
+			}
+			for _, s := range series {
+				seriesRows = append(seriesRows, p.Sprintf("%s\t%s\t%d\t%s\t%s",
+					block.Meta().ULID, s.Name, s.Masked, formatBytes(s.Reclaimable), formatIntervals(s.Intervals)))
+			}
+		}
+
+		if withTombstones == 0 {
+			fmt.Println("No block has tombstones.")
+			return
+		}
+
+		fmt.Fprintln(w, "BLOCK\tMETRIC\tSERIES\tMASKED\tRECLAIMABLE")
+		for _, row := range metricRows {
+			fmt.Fprintln(w, row)
+		}
+		if showSeries {
+			fmt.Fprintln(w, "\nBLOCK\tSERIES\tMASKED\tRECLAIMABLE\tINTERVALS")
+			for _, row := range seriesRows {
+				fmt.Fprintln(w, row)
+			}
+		}
+		w.Flush()
+
+		fmt.Printf("\n%d of %d blocks have tombstones, a clean tombstones would reclaim ~%s\n",
+			withTombstones, len(blocks), formatBytes(reclaimable))
+	},
+}
+
+// blockTombstones returns what the tombstones of the block mask by metric and
+// by series, the most reclaimable first.
+func blockTombstones(block *promTsdb.Block) ([]*tombstoneStat, []*tombstoneStat, error) {
+	tombstones, err := block.Tombstones()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tombstones.Close()
+	if tombstones.Total() == 0 {
+		return nil, nil, nil
+	}
+
+	indexReader, err := block.Index()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer indexReader.Close()
+
+	chunkReader, err := block.Chunks()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer chunkReader.Close()
+
+	byMetric := make(map[string]*tombstoneStat)
+	var series []*tombstoneStat
+	err = tombstones.Iter(func(ref uint64, intervals promTsdb.Intervals) error {
+		var lset promTsdbLabels.Labels
+		var chks []chunks.Meta
+		if err := indexReader.Series(ref, &lset, &chks); err != nil {
+			return err
+		}
+
+		s := &tombstoneStat{Name: lset.String(), Series: 1, Intervals: intervals}
+		var total, bytes int
+		for _, chk := range chks {
+			c, err := chunkReader.Chunk(chk.Ref)
+			if err != nil {
+				return err
+			}
+			bytes += len(c.Bytes())
+			it := c.Iterator()
+			for it.Next() {
+				t, _ := it.At()
+				total++
+				if inIntervals(t, intervals) {
+					s.Masked++
+				}
+			}
+		}
+		if total > 0 {
+			s.Reclaimable = bytes * s.Masked / total
+		}
+		series = append(series, s)
+
+		metric := lset.Get("__name__")
+		m, ok := byMetric[metric]
+		if !ok {
+			m = &tombstoneStat{Name: metric}
+			byMetric[metric] = m
+		}
+		m.Series++
+		m.Masked += s.Masked
+		m.Reclaimable += s.Reclaimable
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metrics []*tombstoneStat
+	for _, m := range byMetric {
+		metrics = append(metrics, m)
+	}
+	sortTombstoneStats(metrics)
+	sortTombstoneStats(series)
+	return metrics, series, nil
+}
+
+func sortTombstoneStats(stat []*tombstoneStat) {
+	sort.Slice(stat, func(i, j int) bool {
+		if stat[i].Reclaimable != stat[j].Reclaimable {
+			return stat[i].Reclaimable > stat[j].Reclaimable
+		}
+		return stat[i].Name < stat[j].Name
+	})
+}
+
+// inIntervals tells whether a deleted interval holds the timestamp. The
+// intervals are closed, like the ones of the delete API.
+func inIntervals(t int64, intervals promTsdb.Intervals) bool {
+	for _, iv := range intervals {
+		if t >= iv.Mint && t <= iv.Maxt {
+			return true
+		}
+	}
+	return false
+}
+
+func formatIntervals(intervals promTsdb.Intervals) string {
+	var s []string
+	for _, iv := range intervals {
+		s = append(s, fmt.Sprintf("%s - %s",
+			time.Unix(iv.Mint/1000, 0).Format(time.RFC3339),
+			time.Unix(iv.Maxt/1000, 0).Format(time.RFC3339)))
+	}
+	return strings.Join(s, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(tombstonesCmd)
+	tombstonesCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	tombstonesCmd.PersistentFlags().BoolVar(&showSeries, "series", false, "Lists the deleted intervals of every series.")
+}
@@ -0,0 +1,171 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/laszlocph/tsdbinfo/pkg/usage"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var ruleFiles []string
+var dashboardDirs []string
+
+// unusedCmd represents the unused command
+var unusedCmd = &cobra.Command{
+	Use:   "unused",
+	Short: "To find the metrics that no rule or dashboard references",
+	Long: `
+Extracts the metric selectors from the PromQL expressions of your recording and alerting rule files, and of the panel targets
+and template variables of your exported Grafana dashboards. Then lists the metrics of the selected blocks that none of them
+references. The metrics with most bytes come first, so you get a safe-to-drop list with the cost attached.
+Select blocks with --block, or leave it empty to use all of them.
+
+--dashboards takes directories, every .json file in them is read as a dashboard export. It works offline, no Grafana is needed.
+Template variables in metric names, like node_${resource}_total, match any text, so the selector references every metric
+the name could expand to.
+
+A selector without a metric name, like {job="api"} or an invalid __name__ regular expression, may reference any metric. The
+expressions with such selectors are listed as a warning, and no metric is reported while they are there.
+
+NOTE: It does a sequencial scan on the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo unused --storage.tsdb.path.copy=/my/prometheus/path/data --rules='rules/*.yml' --dashboards=dashboards/ --no-bar --top=3
+  METRIC                                  SERIES    SAMPLES          BYTES            BYTES %
+  solr_metrics_core_time_seconds_total    4,229     164,291,959      21,475,002       5%
+  solr_metrics_core_timeouts_total        4,229     164,291,959      21,475,002       5%
+  jvm_buffer_pool_used_bytes              1,102     42,617,445       5,540,267        1%
+
+  1,024 of 1,873 metrics are not referenced, they take 38% of the bytes
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if len(ruleFiles) == 0 && len(dashboardDirs) == 0 {
+			fmt.Fprintln(os.Stderr, "error: set --rules or --dashboards")
+			os.Exit(2)
+		}
+
+		used := usage.New()
+		var exprs []string
+		if len(ruleFiles) > 0 {
+			ruleExprs, err := usage.LoadRules(ruleFiles)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s", err)
+				os.Exit(2)
+			}
+			exprs = append(exprs, ruleExprs...)
+		}
+		if len(dashboardDirs) > 0 {
+			dashboardExprs, err := usage.LoadDashboards(dashboardDirs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s", err)
+				os.Exit(2)
+			}
+			exprs = append(exprs, dashboardExprs...)
+		}
+		for _, expr := range exprs {
+			used.Add(expr)
+		}
+		if len(used.Unresolved) > 0 {
+			// they may reference any metric, so none of them is reported
+			fmt.Fprintf(os.Stderr, "warning: %d expressions have selectors without a metric name, every metric counts as referenced by them:\n", len(used.Unresolved))
+			for _, expr := range used.Unresolved {
+				fmt.Fprintf(os.Stderr, "  %s\n", expr)
+			}
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		metrics, err := collectCosts(blocks, func(lset promTsdbLabels.Labels) []string {
+			return []string{lset.Get("__name__")}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading blocks failed: %s", err)
+			os.Exit(1)
+		}
+
+		var unused []*cost
+		var totalBytes, unusedBytes int
+		for _, m := range metrics {
+			totalBytes += m.Bytes
+			if used.Count(m.Group[0]) == 0 {
+				unused = append(unused, m)
+				unusedBytes += m.Bytes
+			}
+		}
+
+		// metrics with most bytes
+		sort.Slice(unused, func(i, j int) bool {
+			if unused[i].Bytes != unused[j].Bytes {
+				return unused[i].Bytes > unused[j].Bytes
+			}
+			return unused[i].Group[0] < unused[j].Group[0]
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "METRIC\tSERIES\tSAMPLES\tBYTES\tBYTES %")
+		p := message.NewPrinter(language.English)
+
+		numUnused := len(unused)
+		if top < len(unused) {
+			unused = unused[:top]
+		}
+		for _, m := range unused {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%.0f%%\n",
+				m.Group[0],
+				p.Sprint(m.Series()),
+				p.Sprint(m.Samples),
+				p.Sprint(m.Bytes),
+				percent(m.Bytes, totalBytes),
+			)
+		}
+		fmt.Fprint(w, p.Sprintf("\n%d of %d metrics are not referenced, they take %.0f%% of the bytes\n", numUnused, len(metrics), percent(unusedBytes, totalBytes)))
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unusedCmd)
+	unusedCmd.PersistentFlags().StringSliceVar(&ruleFiles, "rules", nil, "Prometheus rule files, glob patterns are expanded.")
+	unusedCmd.PersistentFlags().StringSliceVar(&dashboardDirs, "dashboards", nil, "Directories with exported Grafana dashboard JSON files.")
+	unusedCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	unusedCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	unusedCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -0,0 +1,170 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	"github.com/laszlocph/tsdbinfo/pkg/usage"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var queryLogFiles []string
+
+// usageCmd represents the usage command
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "To compare the cost of metrics with how often they are queried",
+	Long: `
+Counts how often each metric and label appears in the queries of Prometheus query log files (--query-log-file),
+and joins the counts with the cost of the metrics in the selected blocks. Select blocks with --block, or leave it empty
+to use all of them.
+
+Metrics are ranked by bytes per query: expensive metrics nobody queries come first, ordered by their bytes, then the ones
+that are queried the least for their cost.
+
+NOTE: It does a sequencial scan on the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo usage --storage.tsdb.path.copy=/my/prometheus/path/data --query-log=queries.log --no-bar --top=3
+  METRIC                                  SERIES    SAMPLES          BYTES            QUERIES
+  solr_metrics_core_time_seconds_total    4,229     164,291,959      21,475,002       0
+  jvm_buffer_pool_used_bytes              1,102     42,617,445       5,540,267        0
+  solr_metrics_core_errors_total          4,229     164,291,959      21,475,002       3
+
+  LABEL       QUERIES
+  job         1,672
+  instance    803
+  code        97
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		if len(queryLogFiles) == 0 {
+			fmt.Fprintln(os.Stderr, "error: set --query-log")
+			os.Exit(2)
+		}
+
+		queries, skipped, err := usage.LoadQueryLog(queryLogFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "warning: skipped %d invalid query log lines\n", skipped)
+		}
+		used := usage.New()
+		for _, query := range queries {
+			used.Add(query)
+		}
+
+		db, err := common.Open(storagePath, noPromLogs)
+		if err != nil {
+			fmt.Printf("opening storage failed: %s", err)
+			os.Exit(1)
+		}
+
+		blocks, err := selectBlocks(db, blockIds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(2)
+		}
+
+		metrics, err := collectCosts(blocks, func(lset promTsdbLabels.Labels) []string {
+			return []string{lset.Get("__name__")}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading blocks failed: %s", err)
+			os.Exit(1)
+		}
+
+		var stat []*cost
+		queryCount := make(map[*cost]int)
+		for _, m := range metrics {
+			stat = append(stat, m)
+			queryCount[m] = used.Count(m.Group[0])
+		}
+
+		// most bytes per query, unqueried metrics first
+		sort.Slice(stat, func(i, j int) bool {
+			qi, qj := queryCount[stat[i]], queryCount[stat[j]]
+			if (qi == 0) != (qj == 0) {
+				return qi == 0
+			}
+			if qi == 0 {
+				return stat[i].Bytes > stat[j].Bytes
+			}
+			return float64(stat[i].Bytes)/float64(qi) > float64(stat[j].Bytes)/float64(qj)
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "METRIC\tSERIES\tSAMPLES\tBYTES\tQUERIES")
+		p := message.NewPrinter(language.English)
+
+		if top < len(stat) {
+			stat = stat[:top]
+		}
+		for _, m := range stat {
+			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\n",
+				m.Group[0],
+				p.Sprint(m.Series()),
+				p.Sprint(m.Samples),
+				p.Sprint(m.Bytes),
+				p.Sprint(queryCount[m]),
+			)
+		}
+
+		var labels []string
+		for label := range used.Labels {
+			labels = append(labels, label)
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			if used.Labels[labels[i]] != used.Labels[labels[j]] {
+				return used.Labels[labels[i]] > used.Labels[labels[j]]
+			}
+			return labels[i] < labels[j]
+		})
+		if top < len(labels) {
+			labels = labels[:top]
+		}
+		if len(labels) > 0 {
+			fmt.Fprintln(w, "\nLABEL\tQUERIES")
+			for _, label := range labels {
+				fmt.Fprintf(w, "%s\t%v\n", label, p.Sprint(used.Labels[label]))
+			}
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	usageCmd.PersistentFlags().StringSliceVar(&queryLogFiles, "query-log", nil, "Prometheus query log files.")
+	usageCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to inspect. Default: all blocks")
+	usageCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+	usageCmd.PersistentFlags().BoolVar(&no_bar, "no-bar", false, "To hide the progressbar. In case you want to process the results.")
+}
@@ -0,0 +1,112 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/laszlocph/tsdbinfo/pkg/verify"
+	"github.com/oklog/ulid"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "To check the integrity of the blocks",
+	Long: `
+Checks each block end to end: it validates meta.json, the checksums of the index sections, the chunks and the tombstones,
+that every chunk reference of the index points to a chunk in its segment file, that the series are sorted and that the
+postings match the series. Select blocks with --block, or leave it empty to check all of them.
+
+It reads the block directories itself, so it works on blocks Prometheus can't open. OFFSET is the byte offset of the
+problem in the file. It exits with 1 if it finds any problem.
+
+NOTE: It reads every file of the selected blocks so it may take a long time
+
+Example usage:
+
+  ➜  tsdbinfo verify --storage.tsdb.path.copy=/my/prometheus/path/data
+  BLOCK                         FILE              OFFSET       PROBLEM
+  01CZWK46GK8BVHQCRNNS763NS3    -                 -            ok
+  01D1EFWJ44G9WGN7AQ9398G2W2    chunks/000001     1048592      chunk checksum mismatch
+  01D1EFWJ44G9WGN7AQ9398G2W2    index             -            chunk 3 of series {__name__="up", instance="10.0.3.17:9100", job="node"} references segment 0 offset 1048592, where no chunk starts
+  01D1EFWJRQ35VYNT2M4YYEJV3R    -                 -            ok
+
+  1 of 3 blocks are corrupted
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		ids := blockIds
+		if len(ids) == 0 {
+			dirs, err := ioutil.ReadDir(storagePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s", err)
+				os.Exit(1)
+			}
+			for _, dir := range dirs {
+				if _, err := ulid.Parse(dir.Name()); err == nil && dir.IsDir() {
+					ids = append(ids, dir.Name())
+				}
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "BLOCK\tFILE\tOFFSET\tPROBLEM")
+
+		var corrupted int
+		for _, id := range ids {
+			dir := filepath.Join(storagePath, id)
+			if _, err := os.Stat(dir); err != nil {
+				fmt.Fprintf(os.Stderr, "error: can't find block with id %s", id)
+				os.Exit(2)
+			}
+
+			problems := verify.Block(dir)
+			if len(problems) == 0 {
+				fmt.Fprintf(w, "%s\t-\t-\tok\n", id)
+				continue
+			}
+			corrupted++
+			for _, problem := range problems {
+				offset := "-"
+				if problem.Offset >= 0 {
+					offset = fmt.Sprint(problem.Offset)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, problem.File, offset, problem.Message)
+			}
+		}
+		w.Flush()
+
+		if corrupted > 0 {
+			fmt.Printf("\n%d of %d blocks are corrupted\n", corrupted, len(ids))
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.PersistentFlags().StringSliceVar(&blockIds, "block", nil, "The IDs of the TSDB blocks to check. Default: all blocks")
+}
@@ -0,0 +1,461 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laszlocph/tsdbinfo/pkg/common"
+	promTsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/wal"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// walSegment is a WAL segment file, in the WAL directory or in a checkpoint.
+type walSegment struct {
+	Dir   string
+	Index int
+	// Name is relative to the WAL directory.
+	Name string
+	Size int64
+}
+
+// walSegmentStat is what a WAL segment holds.
+type walSegmentStat struct {
+	Segment    walSegment
+	Series     int
+	Samples    int
+	Tombstones int
+	MinTime    int64
+	MaxTime    int64
+	Corruption error
+}
+
+// walMetricStat is what the WAL holds of a metric.
+type walMetricStat struct {
+	Metric  string
+	Series  int
+	Samples int
+}
+
+// walCmd represents the wal command
+var walCmd = &cobra.Command{
+	Use:   "wal",
+	Short: "To inspect the write ahead log",
+	Long: `
+Reads the WAL under the TSDB path, where the newest hours of data live before they are compacted into a block.
+It reads the segments Prometheus replays on start: the ones of the last checkpoint, then the segments after it.
+Segments already covered by the checkpoint are listed as skipped.
+
+The WAL is only read, it is not repaired or truncated. CORRUPTION is the position of the first corrupted record
+of a segment, the records after it are not read. It exits with 1 if any segment is corrupted.
+
+Example usage:
+
+  ➜  tsdbinfo wal --storage.tsdb.path.copy=/my/prometheus/path/data --top=3
+  SEGMENT                       SIZE         SERIES     SAMPLES       TOMBSTONES    FROM                         UNTIL                        CORRUPTION
+  checkpoint.000041/00000000    31.5 MiB     310,512    0             0             -                            -
+  00000042                      128.0 MiB    1,021      8,104,233     0             2019-01-17T01:00:02+01:00    2019-01-17T01:58:11+01:00
+  00000043                      52.3 MiB     412        3,300,102     0             2019-01-17T01:58:11+01:00    2019-01-17T02:21:40+01:00    at 54788096: unexpected checksum 9a1c27e3, expected 5d80e1f4
+
+  METRIC                                  SERIES     SAMPLES
+  solr_metrics_core_time_seconds_total    4,229      1,130,911
+  kube_pod_container_status_restarts      110,021    1,004,833
+  node_cpu_seconds_total                  96         25,344
+
+  The WAL covers 2019-01-17T01:00:02+01:00 - 2019-01-17T02:21:40+01:00 (1h21m38s), 311,945 series, 11,404,335 samples
+
+  1 of 3 segments are corrupted
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		segments, skipped, err := walSegments(filepath.Join(storagePath, "wal"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+
+		var dec promTsdb.RecordDecoder
+		var series []promTsdb.RefSeries
+		var samples []promTsdb.RefSample
+		var stones []promTsdb.Stone
+
+		metricOf := make(map[uint64]string)
+		byMetric := make(map[string]*walMetricStat)
+		metricStat := func(metric string) *walMetricStat {
+			m, ok := byMetric[metric]
+			if !ok {
+				m = &walMetricStat{Metric: metric}
+				byMetric[metric] = m
+			}
+			return m
+		}
+
+		var stat []*walSegmentStat
+		var corrupted int
+		for _, segment := range segments {
+			s := &walSegmentStat{Segment: segment, MinTime: math.MaxInt64, MaxTime: math.MinInt64}
+			s.Corruption = readWALSegment(segment, func(rec []byte) error {
+				switch dec.Type(rec) {
+				case promTsdb.RecordSeries:
+					series, err = dec.Series(rec, series[:0])
+					if err != nil {
+						return err
+					}
+					s.Series += len(series)
+					for _, rs := range series {
+						metric := rs.Labels.Get("__name__")
+						metricOf[rs.Ref] = metric
+						metricStat(metric).Series++
+					}
+				case promTsdb.RecordSamples:
+					samples, err = dec.Samples(rec, samples[:0])
+					if err != nil {
+						return err
+					}
+					s.Samples += len(samples)
+					for _, rs := range samples {
+						metric, ok := metricOf[rs.Ref]
+						if !ok {
+							metric = "<unknown series>"
+						}
+						metricStat(metric).Samples++
+						if rs.T < s.MinTime {
+							s.MinTime = rs.T
+						}
+						if rs.T > s.MaxTime {
+							s.MaxTime = rs.T
+						}
+					}
+				case promTsdb.RecordTombstones:
+					stones, err = dec.Tombstones(rec, stones[:0])
+					if err != nil {
+						return err
+					}
+					s.Tombstones += len(stones)
+				default:
+					if len(rec) == 0 {
+						return fmt.Errorf("empty record")
+					}
+					return fmt.Errorf("invalid record type %d", rec[0])
+				}
+				return nil
+			})
+			if s.Corruption != nil {
+				corrupted++
+			}
+			stat = append(stat, s)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		p := message.NewPrinter(language.English)
+
+		fmt.Fprintln(w, "SEGMENT\tSIZE\tSERIES\tSAMPLES\tTOMBSTONES\tFROM\tUNTIL\tCORRUPTION")
+		for _, segment := range skipped {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\tskipped, covered by the checkpoint\n", segment.Name, formatBytes(int(segment.Size)))
+		}
+		var minTime, maxTime int64 = math.MaxInt64, math.MinInt64
+		var numSeries, numSamples int
+		for _, s := range stat {
+			from, until := "-", "-"
+			if s.Samples > 0 {
+				from = time.Unix(s.MinTime/1000, 0).Format(time.RFC3339)
+				until = time.Unix(s.MaxTime/1000, 0).Format(time.RFC3339)
+				if s.MinTime < minTime {
+					minTime = s.MinTime
+				}
+				if s.MaxTime > maxTime {
+					maxTime = s.MaxTime
+				}
+			}
+			corruption := ""
+			if s.Corruption != nil {
+				corruption = s.Corruption.Error()
+			}
+			numSeries += s.Series
+			numSamples += s.Samples
+			fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%v\t%s\t%s\t%s\n",
+				s.Segment.Name,
+				formatBytes(int(s.Segment.Size)),
+				p.Sprint(s.Series),
+				p.Sprint(s.Samples),
+				p.Sprint(s.Tombstones),
+				from,
+				until,
+				corruption,
+			)
+		}
+
+		var metrics []*walMetricStat
+		for _, m := range byMetric {
+			metrics = append(metrics, m)
+		}
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].Samples != metrics[j].Samples {
+				return metrics[i].Samples > metrics[j].Samples
+			}
+			return metrics[i].Metric < metrics[j].Metric
+		})
+		if top < len(metrics) {
+			metrics = metrics[:top]
+		}
+		if len(metrics) > 0 {
+			fmt.Fprintln(w, "\nMETRIC\tSERIES\tSAMPLES")
+			for _, m := range metrics {
+				fmt.Fprintf(w, "%s\t%v\t%v\n", m.Metric, p.Sprint(m.Series), p.Sprint(m.Samples))
+			}
+		}
+		w.Flush()
+
+		if numSamples > 0 {
+			p.Printf("\nThe WAL covers %s - %s (%s), %d series, %d samples\n",
+				time.Unix(minTime/1000, 0).Format(time.RFC3339),
+				time.Unix(maxTime/1000, 0).Format(time.RFC3339),
+				(time.Duration(maxTime-minTime) * time.Millisecond).Truncate(time.Second),
+				numSeries,
+				numSamples,
+			)
+		}
+
+		if corrupted > 0 {
+			fmt.Printf("\n%d of %d segments are corrupted\n", corrupted, len(stat))
+			os.Exit(1)
+		}
+	},
+}
+
+// walSegments returns the segments the head replays from the WAL directory:
+// the ones of the last checkpoint, then the segments after the checkpoint.
+// The segments the checkpoint covers are returned as skipped.
+func walSegments(dir string) (segments []walSegment, skipped []walSegment, err error) {
+	checkpoint, checkpointIndex := "", -1
+	checkpointDir, index, err := promTsdb.LastCheckpoint(dir)
+	switch err {
+	case nil:
+		checkpoint, checkpointIndex = checkpointDir, index
+		s, err := listWALSegments(dir, checkpoint)
+		if err != nil {
+			return nil, nil, err
+		}
+		segments = append(segments, s...)
+	case promTsdb.ErrNotFound:
+	default:
+		return nil, nil, err
+	}
+
+	s, err := listWALSegments(dir, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, segment := range s {
+		if segment.Index <= checkpointIndex {
+			skipped = append(skipped, segment)
+		} else {
+			segments = append(segments, segment)
+		}
+	}
+	return segments, skipped, nil
+}
+
+// listWALSegments returns the segment files of the directory, in order.
+func listWALSegments(walDir, dir string) ([]walSegment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []walSegment
+	for _, fi := range files {
+		index, err := strconv.Atoi(fi.Name())
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		name, err := filepath.Rel(walDir, filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, walSegment{Dir: dir, Index: index, Name: name, Size: fi.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Index < segments[j].Index
+	})
+	return segments, nil
+}
+
+// readWALSegment calls fn for every record of the segment, records don't
+// cross segments. It returns the first corruption, or the first error of fn
+// along with the position of its record.
+func readWALSegment(segment walSegment, fn func(rec []byte) error) error {
+	sr, err := wal.NewSegmentsRangeReader(wal.SegmentRange{Dir: segment.Dir, First: segment.Index, Last: segment.Index})
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	r := wal.NewReader(sr)
+	for r.Next() {
+		if err := fn(r.Record()); err != nil {
+			return fmt.Errorf("record at %d: %s", r.Offset(), err)
+		}
+	}
+	if err, ok := r.Err().(*wal.CorruptionErr); ok {
+		return fmt.Errorf("at %d: %s", err.Offset, err.Err)
+	}
+	return r.Err()
+}
+
+// loadHead builds an in-memory head from the WAL directory, the way
+// Prometheus replays it on start, without writing to the directory. Every
+// series record creates its series, samples the head doesn't accept, of unknown
+// series, out of order or too old for the head range, are dropped and counted. Reading stops
+// at the first corruption, which is returned along with the head of the
+// records before it. Tombstone records are not applied.
+func loadHead(dir string) (head *promTsdb.Head, dropped int, corruption error, err error) {
+	segments, _, err := walSegments(dir)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	head, err = promTsdb.NewHead(nil, nil, nil, common.BlockRanges[0])
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// refs maps the series references of the WAL to the ones of the head
+	refs := make(map[uint64]uint64)
+	// lastTime is the time of the newest sample of a series, the appender
+	// only checks the order against the committed samples
+	lastTime := make(map[uint64]int64)
+	// An empty head takes its time range from the first sample added, even if
+	// it's rolled back, so the series that come before any sample are
+	// created at the time of the oldest sample.
+	oldest := oldestSample(segments)
+	// createSeries creates the series without samples: the appender creates
+	// the series of the samples it's given even if it rolls back.
+	createSeries := func(series []promTsdb.RefSeries) {
+		t := head.MaxTime()
+		if head.MinTime() == math.MaxInt64 {
+			t = oldest
+		}
+		app := head.Appender()
+		for _, s := range series {
+			// a series already in the head is returned with an error
+			if ref, _ := app.Add(s.Labels, t, 0); ref != 0 {
+				refs[s.Ref] = ref
+			}
+		}
+		app.Rollback()
+	}
+
+	var dec promTsdb.RecordDecoder
+	var series []promTsdb.RefSeries
+	var samples []promTsdb.RefSample
+	for _, segment := range segments {
+		corruption = readWALSegment(segment, func(rec []byte) error {
+			switch dec.Type(rec) {
+			case promTsdb.RecordSeries:
+				series, err = dec.Series(rec, series[:0])
+				if err != nil {
+					return err
+				}
+				createSeries(series)
+			case promTsdb.RecordSamples:
+				samples, err = dec.Samples(rec, samples[:0])
+				if err != nil {
+					return err
+				}
+				if len(samples) == 0 {
+					return nil
+				}
+				app := head.Appender()
+				for _, s := range samples {
+					ref, ok := refs[s.Ref]
+					if !ok {
+						dropped++
+						continue
+					}
+					if last, ok := lastTime[s.Ref]; ok && s.T <= last {
+						dropped++
+						continue
+					}
+					if err := app.AddFast(ref, s.T, s.V); err != nil {
+						dropped++
+						continue
+					}
+					lastTime[s.Ref] = s.T
+				}
+				return app.Commit()
+			}
+			return nil
+		})
+		if corruption != nil {
+			break
+		}
+	}
+	return head, dropped, corruption, nil
+}
+
+// oldestSample returns the time of the oldest sample in the segments, up to
+// the first corruption, or 0 if there are no samples.
+func oldestSample(segments []walSegment) int64 {
+	oldest := int64(math.MaxInt64)
+	var dec promTsdb.RecordDecoder
+	var samples []promTsdb.RefSample
+	for _, segment := range segments {
+		err := readWALSegment(segment, func(rec []byte) error {
+			if dec.Type(rec) != promTsdb.RecordSamples {
+				return nil
+			}
+			var err error
+			samples, err = dec.Samples(rec, samples[:0])
+			if err != nil {
+				return err
+			}
+			for _, s := range samples {
+				if s.T < oldest {
+					oldest = s.T
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	if oldest == math.MaxInt64 {
+		return 0
+	}
+	return oldest
+}
+
+func init() {
+	rootCmd.AddCommand(walCmd)
+	walCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+}
@@ -0,0 +1,283 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	promTsdb "github.com/prometheus/tsdb"
+	promTsdbLabels "github.com/prometheus/tsdb/labels"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// walRecordTypes are the names of the WAL record types.
+var walRecordTypes = map[promTsdb.RecordType]string{
+	promTsdb.RecordSeries:     "series",
+	promTsdb.RecordSamples:    "samples",
+	promTsdb.RecordTombstones: "tombstones",
+	promTsdb.RecordInvalid:    "invalid",
+}
+
+// walUsage is the disk usage of a WAL segment, or of a metric's records.
+type walUsage struct {
+	Name        string
+	Size        int64
+	Series      int
+	SeriesBytes int
+	Samples     int
+	SampleBytes int
+	Other       int
+}
+
+// Overhead is what the segment takes beside the records: the record
+// headers, the page padding and the unused tail of the last page.
+func (u *walUsage) Overhead() int {
+	return int(u.Size) - u.SeriesBytes - u.SampleBytes - u.Other
+}
+
+// walUsageCmd represents the wal-usage command
+var walUsageCmd = &cobra.Command{
+	Use:   "wal-usage",
+	Short: "To explain the disk usage of the write ahead log",
+	Long: `
+Reads every segment file under the WAL directory of the TSDB path, including the checkpoint and the segments the checkpoint
+already covers, and attributes their bytes to the series, samples and tombstone records. A large WAL slows the restart of
+Prometheus, as the whole WAL is replayed.
+
+- Series records are written when a series is created, and copied into every checkpoint while the series is in the head.
+  Their share grows with series churn, like pods coming and going.
+- Sample records grow with the sample volume: the number of series times the scrape frequency.
+
+The checkpoint line tells how many series the checkpoint keeps series records for, and how many of them got no sample after
+the checkpoint: these only live on until the next head compaction. The metrics are ranked by the bytes of their series records.
+
+The WAL is only read.
+
+Example usage:
+
+  ➜  tsdbinfo wal-usage --storage.tsdb.path.copy=/my/prometheus/path/data --top=3
+  SEGMENT                       SIZE         SERIES      SAMPLES      OTHER    OVERHEAD
+  checkpoint.000041/00000000    31.5 MiB     31.4 MiB    0 B          0 B      87.2 KiB
+  00000042                      128.0 MiB    1.1 MiB     126.5 MiB    0 B      360.4 KiB
+  00000043                      52.3 MiB     441.0 KiB   51.7 MiB     0 B      147.9 KiB
+
+  TYPE          RECORDS    BYTES        SHARE
+  samples       90,311     178.2 MiB    84.7%
+  series        4,408      32.9 MiB     15.6%
+  tombstones    0          0 B          0.0%
+
+  METRIC                                SERIES     SERIES BYTES    SAMPLES
+  kube_pod_container_status_restarts    110,133    17.1 MiB        1,004,833
+  kube_pod_info                         108,790    16.8 MiB        990,114
+  solr_metrics_core_time_seconds_total  4,229      421.7 KiB       1,130,911
+
+  The checkpoint retains the series records of 310,512 series (31.4 MiB), 106,881 of them got no sample after the checkpoint
+  Sample records take 84.7% of the record bytes, the WAL size is driven by the sample volume
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storagePath == "" {
+			fmt.Fprintln(os.Stderr, "error: set --storage.tsdb.path.copy")
+			os.Exit(1)
+		}
+
+		segments, skipped, err := walSegments(filepath.Join(storagePath, "wal"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s", err)
+			os.Exit(1)
+		}
+		numSkipped := len(skipped)
+		segments = append(skipped, segments...)
+
+		var dec promTsdb.RecordDecoder
+		var series []promTsdb.RefSeries
+		var samples []promTsdb.RefSample
+
+		records := make(map[promTsdb.RecordType]int)
+		recordBytes := make(map[promTsdb.RecordType]int)
+		metricOf := make(map[uint64]string)
+		byMetric := make(map[string]*walUsage)
+		metricUsage := func(metric string) *walUsage {
+			u, ok := byMetric[metric]
+			if !ok {
+				u = &walUsage{Name: metric}
+				byMetric[metric] = u
+			}
+			return u
+		}
+
+		var checkpointSeries, checkpointBytes int
+		checkpointed := make(map[uint64]bool)
+		sampled := make(map[uint64]bool)
+
+		var stat []*walUsage
+		for i, segment := range segments {
+			inCheckpoint := strings.HasPrefix(segment.Name, "checkpoint.")
+			afterCheckpoint := i >= numSkipped && !inCheckpoint
+			u := &walUsage{Name: segment.Name, Size: segment.Size}
+			err := readWALSegment(segment, func(rec []byte) error {
+				t := dec.Type(rec)
+				records[t]++
+				recordBytes[t] += len(rec)
+				switch t {
+				case promTsdb.RecordSeries:
+					u.SeriesBytes += len(rec)
+					series, err = dec.Series(rec, series[:0])
+					if err != nil {
+						return err
+					}
+					u.Series += len(series)
+					for _, s := range series {
+						metric := s.Labels.Get("__name__")
+						metricOf[s.Ref] = metric
+						mu := metricUsage(metric)
+						mu.Series++
+						mu.SeriesBytes += seriesRecordBytes(s.Labels)
+						if inCheckpoint {
+							checkpointSeries++
+							checkpointBytes += seriesRecordBytes(s.Labels)
+							checkpointed[s.Ref] = true
+						}
+					}
+				case promTsdb.RecordSamples:
+					u.SampleBytes += len(rec)
+					samples, err = dec.Samples(rec, samples[:0])
+					if err != nil {
+						return err
+					}
+					u.Samples += len(samples)
+					for _, s := range samples {
+						metric, ok := metricOf[s.Ref]
+						if !ok {
+							metric = "<unknown series>"
+						}
+						metricUsage(metric).Samples++
+						if afterCheckpoint {
+							sampled[s.Ref] = true
+						}
+					}
+				default:
+					u.Other += len(rec)
+				}
+				return nil
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: segment %s is read up to the corruption %s\n", segment.Name, err)
+			}
+			stat = append(stat, u)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', tabwriter.TabIndent)
+		p := message.NewPrinter(language.English)
+
+		fmt.Fprintln(w, "SEGMENT\tSIZE\tSERIES\tSAMPLES\tOTHER\tOVERHEAD")
+		for _, u := range stat {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				u.Name,
+				formatBytes(int(u.Size)),
+				formatBytes(u.SeriesBytes),
+				formatBytes(u.SampleBytes),
+				formatBytes(u.Other),
+				formatBytes(u.Overhead()),
+			)
+		}
+
+		var total int
+		for _, b := range recordBytes {
+			total += b
+		}
+		types := []promTsdb.RecordType{promTsdb.RecordSeries, promTsdb.RecordSamples, promTsdb.RecordTombstones, promTsdb.RecordInvalid}
+		sort.SliceStable(types, func(i, j int) bool {
+			return recordBytes[types[i]] > recordBytes[types[j]]
+		})
+		fmt.Fprintln(w, "\nTYPE\tRECORDS\tBYTES\tSHARE")
+		for _, t := range types {
+			if t == promTsdb.RecordInvalid && records[t] == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\t%.1f%%\n", walRecordTypes[t], p.Sprint(records[t]), formatBytes(recordBytes[t]), percent(recordBytes[t], total))
+		}
+
+		var metrics []*walUsage
+		for _, u := range byMetric {
+			metrics = append(metrics, u)
+		}
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].SeriesBytes != metrics[j].SeriesBytes {
+				return metrics[i].SeriesBytes > metrics[j].SeriesBytes
+			}
+			return metrics[i].Name < metrics[j].Name
+		})
+		if top < len(metrics) {
+			metrics = metrics[:top]
+		}
+		if len(metrics) > 0 {
+			fmt.Fprintln(w, "\nMETRIC\tSERIES\tSERIES BYTES\tSAMPLES")
+			for _, u := range metrics {
+				fmt.Fprintf(w, "%s\t%v\t%s\t%v\n", u.Name, p.Sprint(u.Series), formatBytes(u.SeriesBytes), p.Sprint(u.Samples))
+			}
+		}
+		w.Flush()
+
+		fmt.Println()
+		if checkpointSeries > 0 {
+			var idle int
+			for ref := range checkpointed {
+				if !sampled[ref] {
+					idle++
+				}
+			}
+			p.Printf("The checkpoint retains the series records of %d series (%s), %d of them got no sample after the checkpoint\n", checkpointSeries, formatBytes(checkpointBytes), idle)
+		} else {
+			fmt.Println("There is no checkpoint")
+		}
+		if total > 0 {
+			seriesShare := percent(recordBytes[promTsdb.RecordSeries], total)
+			if seriesShare > 50 {
+				fmt.Printf("Series records take %.1f%% of the record bytes, the WAL size is driven by series churn\n", seriesShare)
+			} else {
+				fmt.Printf("Sample records take %.1f%% of the record bytes, the WAL size is driven by the sample volume\n", percent(recordBytes[promTsdb.RecordSamples], total))
+			}
+		}
+	},
+}
+
+// seriesRecordBytes is the size of a series in a series record: the series
+// reference and the length prefixed label names and values.
+func seriesRecordBytes(lset promTsdbLabels.Labels) int {
+	n := 8 + uvarintLen(len(lset))
+	for _, l := range lset {
+		n += uvarintLen(len(l.Name)) + len(l.Name) + uvarintLen(len(l.Value)) + len(l.Value)
+	}
+	return n
+}
+
+func uvarintLen(x int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(x))
+}
+
+func init() {
+	rootCmd.AddCommand(walUsageCmd)
+	walUsageCmd.PersistentFlags().IntVar(&top, "top", 100, "To control the length of the resultset. Default: 100")
+}
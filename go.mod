@@ -8,9 +8,12 @@ require (
 	github.com/gosuri/uiprogress v0.0.1
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/mattn/go-isatty v0.0.7 // indirect
+	github.com/oklog/ulid v1.3.1
 	github.com/prometheus/client_golang v0.9.3
+	github.com/prometheus/common v0.4.0
 	github.com/prometheus/tsdb v0.7.1
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.3 // indirect
 	golang.org/x/text v0.3.2
+	gopkg.in/yaml.v2 v2.2.1
 )
@@ -11,6 +11,17 @@ import (
 	"github.com/prometheus/tsdb"
 )
 
+// BlockRanges are the block ranges Open uses, the ones of Prometheus: 2h
+// growing by a factor of 3 up to the 10th step.
+var BlockRanges = tsdb.ExponentialBlockRanges(int64(time.Hour*2/time.Millisecond), 10, 3)
+
+// Open opens the TSDB. Overlapping blocks are allowed, so they can be
+// inspected. Compactions are disabled, so the background loop of the DB never
+// merges blocks in the copy while it is inspected.
+//
+// Opening still writes to the copy: the TSDB deletes the blocks that a
+// compacted block lists as its parents, repairs the index version of blocks
+// written by Prometheus 2.1, and starts a new WAL segment.
 func Open(storagePath string, noPromLogs bool) (*tsdb.DB, error) {
 	var w io.Writer
 	if noPromLogs {
@@ -25,9 +36,14 @@ func Open(storagePath string, noPromLogs bool) (*tsdb.DB, error) {
 		log.With(logger, "component", "tsdb"),
 		prometheus.DefaultRegisterer,
 		&tsdb.Options{
-			BlockRanges: tsdb.ExponentialBlockRanges(int64(time.Hour*2/time.Millisecond), 10, 3),
+			BlockRanges:            BlockRanges,
+			AllowOverlappingBlocks: true,
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	db.DisableCompactions()
 
-	return db, err
+	return db, nil
 }
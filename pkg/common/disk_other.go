@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package common
+
+import "errors"
+
+// DiskFree is not supported on this platform.
+func DiskFree(path string) (int64, error) {
+	return 0, errors.New("reading the free disk space is not supported on this platform")
+}
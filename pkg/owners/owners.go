@@ -0,0 +1,101 @@
+package owners
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/prometheus/tsdb/labels"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Unassigned is the team of the series that no owner matches, unless the
+// mapping sets a default.
+const Unassigned = "unassigned"
+
+// Mapping assigns series to the teams owning them. The owners are matched in
+// order, the first matching owner gets the series.
+//
+//	default: platform
+//	owners:
+//	- team: payments
+//	  match:
+//	    kubernetes_namespace: payments|checkout
+//	- team: data
+//	  match:
+//	    job: kafka-.*
+//	  metric_prefix: [kafka_, zookeeper_]
+type Mapping struct {
+	Default string  `yaml:"default"`
+	Owners  []Owner `yaml:"owners"`
+}
+
+// Owner matches the series of a team. All label matchers must match, and if
+// metric prefixes are given, the metric name must start with one of them.
+type Owner struct {
+	Team         string            `yaml:"team"`
+	Match        map[string]string `yaml:"match"`
+	MetricPrefix []string          `yaml:"metric_prefix"`
+
+	matchers []labels.Matcher
+}
+
+// Load reads the mapping file. Label matchers are anchored regular expressions.
+func Load(path string) (*Mapping, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mapping{}
+	if err := yaml.UnmarshalStrict(content, m); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %s", path, err)
+	}
+
+	if m.Default == "" {
+		m.Default = Unassigned
+	}
+	for i := range m.Owners {
+		o := &m.Owners[i]
+		if o.Team == "" {
+			return nil, fmt.Errorf("owner #%d in %s has no team", i+1, path)
+		}
+		for name, pattern := range o.Match {
+			matcher, err := labels.NewRegexpMatcher(name, "^(?:"+pattern+")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid matcher %s=~%q of team %s: %s", name, pattern, o.Team, err)
+			}
+			o.matchers = append(o.matchers, matcher)
+		}
+	}
+
+	return m, nil
+}
+
+// Owner returns the team owning the series.
+func (m *Mapping) Owner(lset labels.Labels) string {
+	for _, o := range m.Owners {
+		if o.matches(lset) {
+			return o.Team
+		}
+	}
+	return m.Default
+}
+
+func (o Owner) matches(lset labels.Labels) bool {
+	for _, matcher := range o.matchers {
+		if !matcher.Matches(lset.Get(matcher.Name())) {
+			return false
+		}
+	}
+	if len(o.MetricPrefix) == 0 {
+		return true
+	}
+	metric := lset.Get("__name__")
+	for _, prefix := range o.MetricPrefix {
+		if strings.HasPrefix(metric, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package promconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultScrapeInterval is the scrape interval of Prometheus when the global
+// section doesn't set one.
+const DefaultScrapeInterval = model.Duration(time.Minute)
+
+// Config is the part of prometheus.yml the analysis needs. Other fields are
+// ignored, so any valid Prometheus configuration loads.
+type Config struct {
+	Global        GlobalConfig    `yaml:"global"`
+	ScrapeConfigs []*ScrapeConfig `yaml:"scrape_configs"`
+}
+
+// GlobalConfig holds the defaults of the scrape configs.
+type GlobalConfig struct {
+	ScrapeInterval model.Duration `yaml:"scrape_interval"`
+}
+
+// ScrapeConfig is a scrape_config of prometheus.yml.
+type ScrapeConfig struct {
+	JobName               string           `yaml:"job_name"`
+	ScrapeInterval        model.Duration   `yaml:"scrape_interval"`
+	SampleLimit           int              `yaml:"sample_limit"`
+	LabelLimit            int              `yaml:"label_limit"`
+	LabelValueLengthLimit int              `yaml:"label_value_length_limit"`
+	MetricRelabelConfigs  []*RelabelConfig `yaml:"metric_relabel_configs"`
+}
+
+// RelabelConfig is a relabel rule of metric_relabel_configs.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Action       string   `yaml:"action"`
+}
+
+// Load reads prometheus.yml and fills in the scrape intervals of the scrape
+// configs from the global section.
+func Load(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %s", path, err)
+	}
+
+	if c.Global.ScrapeInterval == 0 {
+		c.Global.ScrapeInterval = DefaultScrapeInterval
+	}
+	for i, sc := range c.ScrapeConfigs {
+		if sc.JobName == "" {
+			return nil, fmt.Errorf("scrape config #%d in %s has no job_name", i+1, path)
+		}
+		if sc.ScrapeInterval == 0 {
+			sc.ScrapeInterval = c.Global.ScrapeInterval
+		}
+		for _, rc := range sc.MetricRelabelConfigs {
+			if rc.Action == "" {
+				rc.Action = "replace"
+			}
+		}
+	}
+	return c, nil
+}
+
+// Job returns the scrape config of the job, or nil if there is none.
+func (c *Config) Job(name string) *ScrapeConfig {
+	for _, sc := range c.ScrapeConfigs {
+		if sc.JobName == name {
+			return sc
+		}
+	}
+	return nil
+}
+
+// Drops returns the number of metric relabel rules that drop series.
+func (sc *ScrapeConfig) Drops() int {
+	var n int
+	for _, rc := range sc.MetricRelabelConfigs {
+		if rc.Action == "drop" || rc.Action == "keep" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,337 @@
+package promql
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Selector is a vector selector found in a PromQL expression.
+type Selector struct {
+	// Metric is the metric name of the selector, empty if it selects by a
+	// __name__ regular expression or by labels only.
+	Metric string
+	// NameRegex is the anchored __name__ regular expression, if any.
+	NameRegex *regexp.Regexp
+	// Labels are the label names used in the matchers.
+	Labels []string
+}
+
+// Matches reports whether the selector may select the metric. A selector
+// without a metric name or a valid __name__ regular expression, like
+// {job="api"}, may select any metric.
+func (s Selector) Matches(metric string) bool {
+	if s.Metric != "" {
+		return s.Metric == metric
+	}
+	if s.NameRegex != nil {
+		return s.NameRegex.MatchString(metric)
+	}
+	return true
+}
+
+// Resolved reports whether the selector names its metrics, by name or by a
+// valid __name__ regular expression.
+func (s Selector) Resolved() bool {
+	return s.Metric != "" || s.NameRegex != nil
+}
+
+// variableRE matches the Grafana template variables $var, ${var} and [[var]].
+var variableRE = regexp.MustCompile(`\$\{[^}]*\}|\$\w+|\[\[\w+(?::\w+)?\]\]`)
+
+// setName sets the metric name of the selector. A name with Grafana variables
+// becomes a __name__ regular expression with .* in place of the variables, as
+// they may expand to any name.
+func (s *Selector) setName(name string) {
+	if !variableRE.MatchString(name) {
+		s.Metric = name
+		return
+	}
+	parts := variableRE.Split(name, -1)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	s.setNameRegex(strings.Join(parts, ".*"))
+}
+
+// setNameRegex sets the __name__ regular expression of the selector, with .*
+// in place of Grafana variables. An invalid expression leaves the selector
+// unresolved.
+func (s *Selector) setNameRegex(expr string) {
+	expr = variableRE.ReplaceAllString(expr, ".*")
+	if re, err := regexp.Compile("^(?:" + expr + ")$"); err == nil {
+		s.NameRegex = re
+	}
+}
+
+// keywords are the identifiers that are not metric names when they are not
+// followed by an opening parenthesis. Like the keyword maps below, they are
+// lower case and matched regardless of case, as PromQL does.
+var keywords = map[string]bool{
+	"and": true, "or": true, "unless": true, "bool": true, "offset": true,
+	"by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true, "inf": true, "nan": true,
+}
+
+// aggregators may be followed by a grouping clause instead of their
+// parenthesized arguments, like sum by (job) (...).
+var aggregators = map[string]bool{
+	"sum": true, "min": true, "max": true, "avg": true, "group": true,
+	"stddev": true, "stdvar": true, "count": true, "count_values": true,
+	"bottomk": true, "topk": true, "quantile": true,
+}
+
+// groupingKeywords are followed by a list of label names.
+var groupingKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true,
+	"group_left": true, "group_right": true,
+}
+
+// Extract returns the vector selectors and the grouping labels of by, without,
+// on, ignoring, group_left and group_right clauses in the expression.
+//
+// It scans the tokens of the expression instead of parsing it, so it also
+// copes with expressions that are not valid PromQL, like Grafana queries with
+// $variables or label_values(...) template functions.
+func Extract(expr string) (selectors []Selector, groupingLabels []string) {
+	l := &lexer{input: []rune(expr)}
+
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case unicode.IsSpace(r):
+			l.pos++
+		case r == '#':
+			l.skipUntil('\n')
+		case r == '"' || r == '\'' || r == '`':
+			l.readString()
+		case r == '[':
+			l.skipUntil(']')
+		case r == '$':
+			name := l.readName()
+			next := l.peek()
+			if variableRE.FindString(name) == name && next != '{' && next != '[' {
+				// a lone variable, like a threshold
+				continue
+			}
+			var s Selector
+			s.setName(name)
+			if next == '{' {
+				s = l.readMatchers(s)
+			}
+			selectors = append(selectors, s)
+		case r == '{':
+			selectors = append(selectors, l.readMatchers(Selector{}))
+		case isIdentStart(r):
+			ident := l.readName()
+			keyword := strings.ToLower(ident)
+			next := l.peek()
+			switch {
+			case groupingKeywords[keyword] && next == '(':
+				l.pos++
+				groupingLabels = append(groupingLabels, l.readLabelList()...)
+			case keyword == "offset":
+				l.skipSpace()
+				l.readNumber()
+			case keywords[keyword] && next != '(' && next != '{':
+			case aggregators[keyword] && next != '{':
+			case next == '(':
+				// function or aggregation call
+			case next == '{':
+				var s Selector
+				s.setName(ident)
+				selectors = append(selectors, l.readMatchers(s))
+			default:
+				var s Selector
+				s.setName(ident)
+				selectors = append(selectors, s)
+			}
+		case isDigit(r) || r == '.':
+			l.readNumber()
+		default:
+			l.pos++
+		}
+	}
+
+	return selectors, groupingLabels
+}
+
+type lexer struct {
+	input []rune
+	pos   int
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isIdentChar(r rune) bool {
+	return isIdentStart(r) || isDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+// peek returns the next non-space rune without consuming it.
+func (l *lexer) peek() rune {
+	l.skipSpace()
+	if l.pos < len(l.input) {
+		return l.input[l.pos]
+	}
+	return 0
+}
+
+func (l *lexer) skipUntil(end rune) {
+	for l.pos < len(l.input) && l.input[l.pos] != end {
+		l.pos++
+	}
+	l.pos++
+}
+
+func (l *lexer) readIdent() string {
+	start := l.pos
+	for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+		l.pos++
+	}
+	return string(l.input[start:l.pos])
+}
+
+// readName consumes a metric name, which in Grafana queries may contain $var,
+// ${var} and [[var]] variables, like node_${resource}_total.
+func (l *lexer) readName() string {
+	start := l.pos
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case isIdentChar(r):
+			l.pos++
+		case r == '$' && l.pos+1 < len(l.input) && (l.input[l.pos+1] == '{' || isIdentChar(l.input[l.pos+1])):
+			l.skipVariable()
+		case r == '[' && l.pos+1 < len(l.input) && l.input[l.pos+1] == '[':
+			l.skipUntil(']')
+			l.pos++
+		default:
+			return string(l.input[start:l.pos])
+		}
+	}
+	return string(l.input[start:])
+}
+
+// readNumber consumes numbers and durations, like 0.5, 1e3 or 5m.
+func (l *lexer) readNumber() {
+	for l.pos < len(l.input) && (isIdentChar(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+}
+
+// readString consumes a quoted string and returns its unquoted value.
+func (l *lexer) readString() string {
+	quote := l.input[l.pos]
+	l.pos++
+	var value []rune
+	for l.pos < len(l.input) && l.input[l.pos] != quote {
+		if l.input[l.pos] == '\\' && quote != '`' && l.pos+1 < len(l.input) {
+			l.pos++
+		}
+		value = append(value, l.input[l.pos])
+		l.pos++
+	}
+	l.pos++
+	return string(value)
+}
+
+// skipVariable consumes Grafana variables: $var and ${var}.
+func (l *lexer) skipVariable() {
+	l.pos++
+	if l.pos < len(l.input) && l.input[l.pos] == '{' {
+		l.skipUntil('}')
+		return
+	}
+	l.readIdent()
+}
+
+// readLabelList consumes the label names of a grouping clause up to the
+// closing parenthesis.
+func (l *lexer) readLabelList() []string {
+	var labels []string
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case r == ')':
+			l.pos++
+			return labels
+		case isIdentStart(r):
+			labels = append(labels, l.readIdent())
+		case r == '"' || r == '\'' || r == '`':
+			labels = append(labels, l.readString())
+		case r == '$':
+			l.skipVariable()
+		default:
+			l.pos++
+		}
+	}
+	return labels
+}
+
+// readMatchers consumes the label matchers of a selector up to the closing
+// brace.
+func (l *lexer) readMatchers(s Selector) Selector {
+	l.pos++
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case r == '}':
+			l.pos++
+			return s
+		case isIdentStart(r) || r == '"':
+			var name string
+			if r == '"' {
+				name = l.readString()
+			} else {
+				name = l.readIdent()
+			}
+			op := l.readOperator()
+			l.skipSpace()
+			var value string
+			if l.pos < len(l.input) && (l.input[l.pos] == '"' || l.input[l.pos] == '\'' || l.input[l.pos] == '`') {
+				value = l.readString()
+			}
+			if op == "" {
+				// a quoted metric name, {"foo"}
+				s.setName(name)
+				continue
+			}
+			if name != "__name__" {
+				s.Labels = append(s.Labels, name)
+				continue
+			}
+			switch op {
+			case "=":
+				s.setName(value)
+			case "=~":
+				s.setNameRegex(value)
+			}
+		case r == '$':
+			l.skipVariable()
+		default:
+			l.pos++
+		}
+	}
+	return s
+}
+
+func (l *lexer) readOperator() string {
+	l.skipSpace()
+	start := l.pos
+	for l.pos < len(l.input) && strings.ContainsRune("=!~", l.input[l.pos]) {
+		l.pos++
+	}
+	return string(l.input[start:l.pos])
+}
@@ -0,0 +1,109 @@
+package promql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// selectorString renders a selector as metric{labels} or ~regex{labels}.
+func selectorString(s Selector) string {
+	name := s.Metric
+	if s.NameRegex != nil {
+		name = "~" + s.NameRegex.String()
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(s.Labels, ","))
+}
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		expr      string
+		selectors []string
+		grouping  []string
+	}{
+		{
+			expr:      `up`,
+			selectors: []string{"up{}"},
+		},
+		{
+			expr:      `rate(http_requests_total{job="api", code=~"5.."}[5m])`,
+			selectors: []string{"http_requests_total{job,code}"},
+		},
+		{
+			expr:      `sum(x) by (le) > 0 and y`,
+			selectors: []string{"x{}", "y{}"},
+			grouping:  []string{"le"},
+		},
+		{
+			expr:      `sum(x) by (le) > 0 AND y`,
+			selectors: []string{"x{}", "y{}"},
+			grouping:  []string{"le"},
+		},
+		{
+			expr:      `sum by (job) (x)`,
+			selectors: []string{"x{}"},
+			grouping:  []string{"job"},
+		},
+		{
+			expr:      `SUM BY (job) (x)`,
+			selectors: []string{"x{}"},
+			grouping:  []string{"job"},
+		},
+		{
+			expr:      `a / Ignoring(instance) Group_Left(team) b UNLESS c`,
+			selectors: []string{"a{}", "b{}", "c{}"},
+			grouping:  []string{"instance", "team"},
+		},
+		{
+			expr:      `x OFFSET 5m > Inf`,
+			selectors: []string{"x{}"},
+		},
+		{
+			expr:      `{__name__=~"node_.*", instance="a"}`,
+			selectors: []string{"~^(?:node_.*)${instance}"},
+		},
+		{
+			expr:      `sum(rate(x{job="$job"}[$__interval])) by ($group)`,
+			selectors: []string{"x{job}"},
+		},
+		{
+			expr:      `rate(node_${resource}_total[5m]) > $threshold`,
+			selectors: []string{`~^(?:node_.*_total)${}`},
+		},
+		{
+			expr:      `node_[[resource]]_seconds offset $offset`,
+			selectors: []string{`~^(?:node_.*_seconds)${}`},
+		},
+		{
+			expr:      `sum(rate($metric{job="api"}[5m]))`,
+			selectors: []string{`~^(?:.*)${job}`},
+		},
+		{
+			expr:      `{__name__=~"jvm_$area.*"} + {__name__="$metric"}`,
+			selectors: []string{`~^(?:jvm_.*.*)${}`, `~^(?:.*)${}`},
+		},
+		{
+			expr:      `{__name__=~"[[:alpha:]]+_total"}`,
+			selectors: []string{`~^(?:[[:alpha:]]+_total)${}`},
+		},
+		{
+			expr:      `count(y) # a comment with z`,
+			selectors: []string{"y{}"},
+		},
+	}
+
+	for _, c := range cases {
+		selectors, grouping := Extract(c.expr)
+		var got []string
+		for _, s := range selectors {
+			got = append(got, selectorString(s))
+		}
+		if !reflect.DeepEqual(got, c.selectors) {
+			t.Errorf("%s: selectors are %q, want %q", c.expr, got, c.selectors)
+		}
+		if !reflect.DeepEqual(grouping, c.grouping) {
+			t.Errorf("%s: grouping labels are %q, want %q", c.expr, grouping, c.grouping)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	labelValuesRE = regexp.MustCompile(`^\s*label_values\((.*)\)\s*$`)
+	metricsRE     = regexp.MustCompile(`^\s*metrics\((.*)\)\s*$`)
+	queryResultRE = regexp.MustCompile(`^\s*query_result\((.*)\)\s*$`)
+)
+
+// LoadDashboards returns the PromQL expressions of the panel targets and the
+// query template variables in the Grafana dashboard JSON exports found in the
+// directories.
+func LoadDashboards(dirs []string) ([]string, error) {
+	var exprs []string
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != ".json" {
+				return nil
+			}
+
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var dashboard interface{}
+			if err := json.Unmarshal(content, &dashboard); err != nil {
+				return fmt.Errorf("parsing %s failed: %s", path, err)
+			}
+			exprs = append(exprs, dashboardExprs(dashboard, false)...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exprs, nil
+}
+
+// dashboardExprs walks the dashboard JSON and collects the expr fields of the
+// panel targets, at any nesting level of rows and panels, and the queries of
+// the template variables.
+func dashboardExprs(node interface{}, templating bool) []string {
+	var exprs []string
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			switch {
+			case key == "expr":
+				if expr, ok := value.(string); ok {
+					exprs = append(exprs, expr)
+				}
+			case key == "query" && templating:
+				switch q := value.(type) {
+				case string:
+					exprs = append(exprs, templateQuery(q))
+				case map[string]interface{}:
+					if query, ok := q["query"].(string); ok {
+						exprs = append(exprs, templateQuery(query))
+					}
+				}
+			default:
+				exprs = append(exprs, dashboardExprs(value, templating || key == "templating")...)
+			}
+		}
+	case []interface{}:
+		for _, value := range n {
+			exprs = append(exprs, dashboardExprs(value, templating)...)
+		}
+	}
+	return exprs
+}
+
+// templateQuery turns the Grafana template functions of a query variable into
+// PromQL: label_values(expr, label) and query_result(expr) into their expr,
+// metrics(regex) into a __name__ selector.
+func templateQuery(query string) string {
+	if m := labelValuesRE.FindStringSubmatch(query); m != nil {
+		args := m[1]
+		if i := strings.LastIndex(args, ","); i != -1 {
+			return args[:i]
+		}
+		// label_values(label) only references a label
+		return ""
+	}
+	if m := metricsRE.FindStringSubmatch(query); m != nil {
+		return fmt.Sprintf("{__name__=~%q}", strings.TrimSpace(m[1]))
+	}
+	if m := queryResultRE.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return query
+}
@@ -0,0 +1,48 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+type queryLogEntry struct {
+	Params struct {
+		Query string `json:"query"`
+	} `json:"params"`
+}
+
+// LoadQueryLog returns the queries of the Prometheus query log files, written
+// with --query-log-file. Lines that are not valid JSON, like a line cut in
+// half while copying the file, are skipped and counted.
+func LoadQueryLog(files []string) (queries []string, skipped int, err error) {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				var entry queryLogEntry
+				if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil || entry.Params.Query == "" {
+					skipped++
+				} else {
+					queries = append(queries, entry.Params.Query)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, 0, err
+			}
+		}
+		f.Close()
+	}
+	return queries, skipped, nil
+}
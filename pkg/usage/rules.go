@@ -0,0 +1,49 @@
+package usage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type ruleFile struct {
+	Groups []struct {
+		Rules []struct {
+			Expr string `yaml:"expr"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// LoadRules returns the expressions of the recording and alerting rules in the
+// Prometheus rule files matching the glob patterns.
+func LoadRules(patterns []string) ([]string, error) {
+	var exprs []string
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no rule files match %s", pattern)
+		}
+
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			var rules ruleFile
+			if err := yaml.Unmarshal(content, &rules); err != nil {
+				return nil, fmt.Errorf("parsing %s failed: %s", file, err)
+			}
+			for _, group := range rules.Groups {
+				for _, rule := range group.Rules {
+					exprs = append(exprs, rule.Expr)
+				}
+			}
+		}
+	}
+	return exprs, nil
+}
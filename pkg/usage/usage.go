@@ -0,0 +1,65 @@
+package usage
+
+import (
+	"github.com/laszlocph/tsdbinfo/pkg/promql"
+)
+
+// Usage counts how often the metrics and labels are referenced by PromQL
+// expressions.
+type Usage struct {
+	Metrics map[string]int
+	Labels  map[string]int
+	// Unresolved are the expressions with selectors that don't name their
+	// metrics, like {job="api"} or an invalid __name__ regular expression.
+	// They may reference any metric.
+	Unresolved []string
+
+	// selectors that select metrics by a __name__ regular expression, or
+	// that may select any metric
+	regexSelectors []promql.Selector
+}
+
+// New returns an empty Usage.
+func New() *Usage {
+	return &Usage{
+		Metrics: make(map[string]int),
+		Labels:  make(map[string]int),
+	}
+}
+
+// Add records the metrics and labels the expression references.
+func (u *Usage) Add(expr string) {
+	selectors, groupingLabels := promql.Extract(expr)
+	unresolved := false
+	for _, s := range selectors {
+		if s.Metric != "" {
+			u.Metrics[s.Metric]++
+		} else {
+			u.regexSelectors = append(u.regexSelectors, s)
+		}
+		if !s.Resolved() {
+			unresolved = true
+		}
+		for _, label := range s.Labels {
+			u.Labels[label]++
+		}
+	}
+	for _, label := range groupingLabels {
+		u.Labels[label]++
+	}
+	if unresolved {
+		u.Unresolved = append(u.Unresolved, expr)
+	}
+}
+
+// Count returns how many times the metric is referenced, either by name, by a
+// __name__ regular expression, or by a selector that may select any metric.
+func (u *Usage) Count(metric string) int {
+	count := u.Metrics[metric]
+	for _, s := range u.regexSelectors {
+		if s.Matches(metric) {
+			count++
+		}
+	}
+	return count
+}
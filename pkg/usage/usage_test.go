@@ -0,0 +1,57 @@
+package usage
+
+import (
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	cases := []struct {
+		name       string
+		exprs      []string
+		counts     map[string]int
+		unresolved int
+	}{
+		{
+			name:   "by name",
+			exprs:  []string{`rate(http_requests_total[5m])`, `http_requests_total > 0`},
+			counts: map[string]int{"http_requests_total": 2, "up": 0},
+		},
+		{
+			name:   "by __name__ regex",
+			exprs:  []string{`{__name__=~"node_.*"}`},
+			counts: map[string]int{"node_load1": 1, "up": 0},
+		},
+		{
+			name:   "Grafana variables",
+			exprs:  []string{`rate(node_${resource}_total[5m])`, `{__name__=~"jvm_[[area]]"}`},
+			counts: map[string]int{"node_cpu_total": 1, "jvm_heap": 1, "up": 0},
+		},
+		{
+			name:       "without a metric name",
+			exprs:      []string{`up`, `absent({job="api"})`},
+			counts:     map[string]int{"up": 2, "node_load1": 1},
+			unresolved: 1,
+		},
+		{
+			name:       "invalid __name__ regex",
+			exprs:      []string{`{__name__=~"node_(.*"}`},
+			counts:     map[string]int{"node_load1": 1, "up": 1},
+			unresolved: 1,
+		},
+	}
+
+	for _, c := range cases {
+		u := New()
+		for _, expr := range c.exprs {
+			u.Add(expr)
+		}
+		for metric, want := range c.counts {
+			if got := u.Count(metric); got != want {
+				t.Errorf("%s: count of %s is %d, want %d", c.name, metric, got, want)
+			}
+		}
+		if len(u.Unresolved) != c.unresolved {
+			t.Errorf("%s: %d unresolved expressions, want %d", c.name, len(u.Unresolved), c.unresolved)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+const (
+	magicTombstones   = 0x0130BA30
+	indexTOCLen       = 6*8 + 4
+	chunkSegmentStart = 8
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Problem is a corruption found in a file of a block. Offset is the byte
+// offset in the file, or -1 when the problem isn't at a single place.
+type Problem struct {
+	File    string
+	Offset  int64
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Offset < 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s at %d: %s", p.File, p.Offset, p.Message)
+}
+
+// block collects the problems of a block and what the checks of the files
+// learn about each other.
+type block struct {
+	dir      string
+	meta     *tsdb.BlockMeta
+	problems []Problem
+	pool     chunkenc.Pool
+
+	// chunk references by segment, in the order they are in the segment
+	chunkRefs [][]uint64
+	samples   uint64
+	// series references found in the series section of the index
+	seriesRefs map[uint64]struct{}
+}
+
+func (b *block) add(file string, offset int64, format string, args ...interface{}) {
+	b.problems = append(b.problems, Problem{File: file, Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// Block checks the block in the directory end to end: meta.json, the
+// checksums of the chunks and of the index sections, that the chunk
+// references of the index point to chunks, that the series are sorted, that
+// the postings match the series, and that the tombstones are well-formed.
+func Block(dir string) []Problem {
+	b := &block{dir: dir, pool: chunkenc.NewPool(), seriesRefs: make(map[uint64]struct{})}
+
+	b.checkMeta()
+	b.checkChunks()
+	if b.checkIndexFile() {
+		b.checkIndex()
+	}
+	b.checkTombstones()
+
+	return b.problems
+}
+
+func (b *block) checkMeta() {
+	content, err := ioutil.ReadFile(filepath.Join(b.dir, "meta.json"))
+	if err != nil {
+		b.add("meta.json", -1, "%s", err)
+		return
+	}
+
+	meta := &tsdb.BlockMeta{}
+	if err := json.Unmarshal(content, meta); err != nil {
+		b.add("meta.json", -1, "invalid JSON: %s", err)
+		return
+	}
+	b.meta = meta
+
+	if meta.Version != 1 {
+		b.add("meta.json", -1, "unknown version %d", meta.Version)
+	}
+	if meta.ULID.String() != filepath.Base(b.dir) {
+		b.add("meta.json", -1, "ULID %s doesn't match the directory name", meta.ULID)
+	}
+	if meta.MinTime >= meta.MaxTime {
+		b.add("meta.json", -1, "minTime %d isn't before maxTime %d", meta.MinTime, meta.MaxTime)
+	}
+	if meta.Compaction.Level < 1 {
+		b.add("meta.json", -1, "compaction level %d is below 1", meta.Compaction.Level)
+	}
+	if len(meta.Compaction.Sources) == 0 {
+		b.add("meta.json", -1, "no compaction sources")
+	}
+}
+
+// checkChunks reads the chunk segments one by one and checks the checksum of
+// every chunk.
+func (b *block) checkChunks() {
+	files, err := ioutil.ReadDir(filepath.Join(b.dir, "chunks"))
+	if err != nil {
+		b.add("chunks", -1, "%s", err)
+		return
+	}
+
+	problems := len(b.problems)
+	var numChunks uint64
+	for _, fi := range files {
+		if _, err := strconv.ParseUint(fi.Name(), 10, 64); err != nil {
+			continue
+		}
+		seq := uint64(len(b.chunkRefs))
+		name := filepath.Join("chunks", fi.Name())
+		refs := b.checkSegment(name, seq)
+		b.chunkRefs = append(b.chunkRefs, refs)
+		numChunks += uint64(len(refs))
+	}
+
+	// the stats can't be compared when chunks couldn't be read
+	if b.meta == nil || len(b.problems) > problems {
+		return
+	}
+	if b.meta.Stats.NumChunks != numChunks {
+		b.add("meta.json", -1, "numChunks is %d, the segments have %d chunks", b.meta.Stats.NumChunks, numChunks)
+	}
+	if b.meta.Stats.NumSamples != b.samples {
+		b.add("meta.json", -1, "numSamples is %d, the chunks have %d samples", b.meta.Stats.NumSamples, b.samples)
+	}
+}
+
+func (b *block) checkSegment(name string, seq uint64) []uint64 {
+	f, err := os.Open(filepath.Join(b.dir, name))
+	if err != nil {
+		b.add(name, -1, "%s", err)
+		return nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		b.add(name, -1, "%s", err)
+		return nil
+	}
+	size := fi.Size()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, chunkSegmentStart)
+	if _, err := io.ReadFull(r, header); err != nil {
+		b.add(name, 0, "reading the header failed: %s", err)
+		return nil
+	}
+	if m := binary.BigEndian.Uint32(header); m != chunks.MagicChunks {
+		b.add(name, 0, "invalid magic number %x", m)
+		return nil
+	}
+	if header[4] != 1 {
+		b.add(name, 4, "unknown format version %d", header[4])
+		return nil
+	}
+
+	var refs []uint64
+	offset := int64(chunkSegmentStart)
+	for {
+		length, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return refs
+		}
+		if err != nil {
+			b.add(name, offset, "reading the chunk length failed: %s", err)
+			return refs
+		}
+
+		// the length comes from the disk, it must fit the rest of the file
+		// before it's allocated
+		left := size - offset - int64(uvarintLen(length)) - 1 - 4
+		if left < 0 || length > uint64(left) {
+			b.add(name, offset, "chunk of %d bytes runs past the end of the segment", length)
+			return refs
+		}
+		data := make([]byte, 1+length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			b.add(name, offset, "chunk of %d bytes runs past the end of the segment", length)
+			return refs
+		}
+		body, sum := data[:1+length], data[1+length:]
+		if crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(sum) {
+			b.add(name, offset, "chunk checksum mismatch")
+		} else if chk, err := b.pool.Get(chunkenc.Encoding(body[0]), body[1:]); err != nil {
+			b.add(name, offset, "%s", err)
+		} else {
+			b.samples += uint64(chk.NumSamples())
+			b.pool.Put(chk)
+		}
+
+		refs = append(refs, seq<<32|uint64(offset))
+		offset += int64(uvarintLen(length)) + int64(len(data))
+	}
+}
+
+// checkIndexFile walks the sections of the index file and checks their
+// checksums. It returns whether the index is intact enough to be read.
+func (b *block) checkIndexFile() bool {
+	const name = "index"
+	content, err := ioutil.ReadFile(filepath.Join(b.dir, name))
+	if err != nil {
+		b.add(name, -1, "%s", err)
+		return false
+	}
+	problems := len(b.problems)
+
+	if len(content) < index.HeaderLen+indexTOCLen {
+		b.add(name, -1, "file of %d bytes is too short", len(content))
+		return false
+	}
+	if m := binary.BigEndian.Uint32(content); m != index.MagicIndex {
+		b.add(name, 0, "invalid magic number %x", m)
+		return false
+	}
+	version := int(content[4])
+	if version != index.FormatV1 && version != index.FormatV2 {
+		b.add(name, 4, "unknown format version %d", version)
+		return false
+	}
+
+	tocStart := int64(len(content) - indexTOCLen)
+	toc := content[tocStart:]
+	if crc32.Checksum(toc[:indexTOCLen-4], castagnoli) != binary.BigEndian.Uint32(toc[indexTOCLen-4:]) {
+		b.add(name, tocStart, "table of contents checksum mismatch")
+		return false
+	}
+	sections := make([]int64, 6)
+	for i := range sections {
+		sections[i] = int64(binary.BigEndian.Uint64(toc[i*8:]))
+	}
+	symbols, series, labelIndices, labelIndicesTable, postings, postingsTable := sections[0], sections[1], sections[2], sections[3], sections[4], sections[5]
+	if !(index.HeaderLen <= symbols && symbols <= series && series <= labelIndices && labelIndices <= postings &&
+		postings <= labelIndicesTable && labelIndicesTable <= postingsTable && postingsTable <= tocStart) {
+		b.add(name, tocStart, "table of contents has sections out of order: %v", sections)
+		return false
+	}
+
+	b.checkIndexEntry(content, symbols, 4, "symbol table")
+
+	// series are 16 byte aligned in version 2, and referenced by offset/16
+	align, refDiv := int64(16), int64(16)
+	if version == index.FormatV1 {
+		align, refDiv = 1, 1
+	}
+	for offset := alignTo(series, align); offset < labelIndices; offset = alignTo(offset, align) {
+		length, n := binary.Uvarint(content[offset:labelIndices])
+		if n <= 0 {
+			b.add(name, offset, "invalid series length")
+			break
+		}
+		left := labelIndices - offset - int64(n) - 4
+		if left < 0 || length > uint64(left) {
+			b.add(name, offset, "series of %d bytes runs past the series section", length)
+			break
+		}
+		end := offset + int64(n) + int64(length) + 4
+		body := content[offset+int64(n) : end-4]
+		if crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(content[end-4:]) {
+			b.add(name, offset, "series checksum mismatch")
+		}
+		b.seriesRefs[uint64(offset/refDiv)] = struct{}{}
+		offset = end
+	}
+
+	for offset := alignTo(labelIndices, 4); offset < postings; offset = alignTo(offset, 4) {
+		end, ok := b.checkIndexEntry(content[:postings], offset, 4, "label index")
+		if !ok {
+			break
+		}
+		offset = end
+	}
+	for offset := alignTo(postings, 4); offset < labelIndicesTable; offset = alignTo(offset, 4) {
+		end, ok := b.checkIndexEntry(content[:labelIndicesTable], offset, 4, "postings list")
+		if !ok {
+			break
+		}
+		offset = end
+	}
+
+	b.checkIndexEntry(content[:postingsTable], labelIndicesTable, 4, "label index table")
+	b.checkIndexEntry(content[:tocStart], postingsTable, 4, "postings table")
+
+	if b.meta != nil && len(b.problems) == problems && b.meta.Stats.NumSeries != uint64(len(b.seriesRefs)) {
+		b.add("meta.json", -1, "numSeries is %d, the index has %d series", b.meta.Stats.NumSeries, len(b.seriesRefs))
+	}
+
+	return len(b.problems) == problems
+}
+
+// checkIndexEntry checks an entry of the index that starts with its length
+// as a 4 byte big endian number, and ends with a checksum. It returns the
+// offset after the entry, and false if the entry doesn't fit in content.
+func (b *block) checkIndexEntry(content []byte, offset int64, lengthLen int64, what string) (int64, bool) {
+	if offset < 0 || offset+lengthLen > int64(len(content)) {
+		b.add("index", offset, "%s runs past its section", what)
+		return 0, false
+	}
+	length := int64(binary.BigEndian.Uint32(content[offset:]))
+	left := int64(len(content)) - offset - lengthLen - 4
+	if left < 0 || length > left {
+		b.add("index", offset, "%s of %d bytes runs past its section", what, length)
+		return 0, false
+	}
+	end := offset + lengthLen + length + 4
+	if crc32.Checksum(content[offset+lengthLen:end-4], castagnoli) != binary.BigEndian.Uint32(content[end-4:]) {
+		b.add("index", offset, "%s checksum mismatch", what)
+	}
+	return end, true
+}
+
+// checkIndex reads the series and postings of an intact index file, and
+// checks that they are sorted, that the chunk references point to chunks and
+// that the postings match the series.
+func (b *block) checkIndex() {
+	const name = "index"
+	r, err := index.NewFileReader(filepath.Join(b.dir, name))
+	if err != nil {
+		b.add(name, -1, "%s", err)
+		return
+	}
+	defer r.Close()
+
+	all, err := r.Postings(index.AllPostingsKey())
+	if err != nil {
+		b.add(name, -1, "reading all postings failed: %s", err)
+		return
+	}
+
+	var (
+		prev    labels.Labels
+		lset    labels.Labels
+		chks    []chunks.Meta
+		last    uint64
+		first   = true
+		numRefs int
+		pairs   = make(map[labels.Label]int)
+	)
+	for all.Next() {
+		ref := all.At()
+		numRefs++
+		if !first && ref <= last {
+			b.add(name, -1, "all postings list isn't sorted at series %d", ref)
+		}
+		if _, ok := b.seriesRefs[ref]; !ok {
+			b.add(name, -1, "all postings list references series %d that isn't in the series section", ref)
+			continue
+		}
+		last = ref
+
+		if err := r.Series(ref, &lset, &chks); err != nil {
+			b.add(name, -1, "reading series %d failed: %s", ref, err)
+			continue
+		}
+		if !first && labels.Compare(prev, lset) >= 0 {
+			b.add(name, -1, "series %s isn't sorted after %s", lset, prev)
+		}
+		for i, l := range lset {
+			if i > 0 && lset[i-1].Name >= l.Name {
+				b.add(name, -1, "labels of series %s aren't sorted", lset)
+			}
+			pairs[l]++
+		}
+		b.checkSeriesChunks(lset, chks)
+
+		prev = append(prev[:0], lset...)
+		first = false
+	}
+	if err := all.Err(); err != nil {
+		b.add(name, -1, "reading all postings failed: %s", err)
+	}
+	if numRefs != len(b.seriesRefs) {
+		b.add(name, -1, "all postings list has %d series, the series section has %d", numRefs, len(b.seriesRefs))
+	}
+
+	names, err := r.LabelNames()
+	if err != nil {
+		b.add(name, -1, "reading label names failed: %s", err)
+		return
+	}
+	for _, labelName := range names {
+		values, err := r.LabelValues(labelName)
+		if err != nil {
+			b.add(name, -1, "reading values of label %s failed: %s", labelName, err)
+			continue
+		}
+		for i := 0; i < values.Len(); i++ {
+			value, err := values.At(i)
+			if err != nil {
+				b.add(name, -1, "reading values of label %s failed: %s", labelName, err)
+				break
+			}
+			b.checkPostings(r, labels.Label{Name: labelName, Value: value[0]}, pairs)
+		}
+	}
+}
+
+func (b *block) checkSeriesChunks(lset labels.Labels, chks []chunks.Meta) {
+	const name = "index"
+	for i, c := range chks {
+		if c.MinTime > c.MaxTime {
+			b.add(name, -1, "chunk %d of series %s has minTime after maxTime", i, lset)
+		}
+		if i > 0 && c.MinTime <= chks[i-1].MaxTime {
+			b.add(name, -1, "chunk %d of series %s overlaps the previous chunk", i, lset)
+		}
+		if b.meta != nil && (c.MinTime < b.meta.MinTime || c.MaxTime >= b.meta.MaxTime) {
+			b.add(name, -1, "chunk %d of series %s is outside the block time range", i, lset)
+		}
+		if !b.isChunk(c.Ref) {
+			b.add(name, -1, "chunk %d of series %s references segment %d offset %d, where no chunk starts", i, lset, c.Ref>>32, uint32(c.Ref))
+		}
+	}
+}
+
+// isChunk tells whether a chunk starts at the reference.
+func (b *block) isChunk(ref uint64) bool {
+	seq := ref >> 32
+	if seq >= uint64(len(b.chunkRefs)) {
+		return false
+	}
+	refs := b.chunkRefs[seq]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i] >= ref })
+	return i < len(refs) && refs[i] == ref
+}
+
+func (b *block) checkPostings(r *index.Reader, pair labels.Label, pairs map[labels.Label]int) {
+	const name = "index"
+	p, err := r.Postings(pair.Name, pair.Value)
+	if err != nil {
+		b.add(name, -1, "reading postings of %s=%q failed: %s", pair.Name, pair.Value, err)
+		return
+	}
+
+	var n int
+	var last uint64
+	for p.Next() {
+		ref := p.At()
+		if n > 0 && ref <= last {
+			b.add(name, -1, "postings of %s=%q aren't sorted at series %d", pair.Name, pair.Value, ref)
+		}
+		if _, ok := b.seriesRefs[ref]; !ok {
+			b.add(name, -1, "postings of %s=%q reference series %d that isn't in the series section", pair.Name, pair.Value, ref)
+		}
+		last = ref
+		n++
+	}
+	if err := p.Err(); err != nil {
+		b.add(name, -1, "reading postings of %s=%q failed: %s", pair.Name, pair.Value, err)
+		return
+	}
+	if n != pairs[pair] {
+		b.add(name, -1, "postings of %s=%q have %d series, %d series have the label", pair.Name, pair.Value, n, pairs[pair])
+	}
+}
+
+// checkTombstones checks the checksum of the tombstones file, and that every
+// deleted interval is well-formed and belongs to a series of the index.
+func (b *block) checkTombstones() {
+	const name = "tombstones"
+	content, err := ioutil.ReadFile(filepath.Join(b.dir, name))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		b.add(name, -1, "%s", err)
+		return
+	}
+
+	if len(content) < 5+4 {
+		b.add(name, -1, "file of %d bytes is too short", len(content))
+		return
+	}
+	if m := binary.BigEndian.Uint32(content); m != magicTombstones {
+		b.add(name, 0, "invalid magic number %x", m)
+		return
+	}
+	if content[4] != 1 {
+		b.add(name, 4, "unknown format version %d", content[4])
+		return
+	}
+	body := content[5 : len(content)-4]
+	if crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(content[len(content)-4:]) {
+		b.add(name, int64(len(content)-4), "checksum mismatch")
+		return
+	}
+
+	for offset := 0; offset < len(body); {
+		start := int64(offset + 5)
+		ref, n := binary.Uvarint(body[offset:])
+		if n <= 0 {
+			b.add(name, start, "invalid series reference")
+			return
+		}
+		offset += n
+		mint, n := binary.Varint(body[offset:])
+		if n <= 0 {
+			b.add(name, start, "invalid interval start")
+			return
+		}
+		offset += n
+		maxt, n := binary.Varint(body[offset:])
+		if n <= 0 {
+			b.add(name, start, "invalid interval end")
+			return
+		}
+		offset += n
+
+		if mint > maxt {
+			b.add(name, start, "interval of series %d starts at %d after it ends at %d", ref, mint, maxt)
+		}
+		if _, ok := b.seriesRefs[ref]; !ok && len(b.seriesRefs) > 0 {
+			b.add(name, start, "series %d isn't in the index", ref)
+		}
+	}
+}
+
+func alignTo(offset, size int64) int64 {
+	if r := offset % size; r != 0 {
+		return offset + size - r
+	}
+	return offset
+}
+
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
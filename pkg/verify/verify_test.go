@@ -0,0 +1,212 @@
+package verify
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// writeBlock writes a small block with a few series into a temporary
+// directory and returns the directory of the block.
+func writeBlock(t *testing.T) string {
+	head, err := tsdb.NewHead(nil, nil, nil, 2*60*60*1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := head.Appender()
+	for i, instance := range []string{"a", "b", "c"} {
+		lset := labels.FromStrings("__name__", "up", "instance", instance)
+		for ts := int64(0); ts < 120; ts++ {
+			if _, err := app.Add(lset, ts*15000, float64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, nil, []int64{2 * 60 * 60 * 1000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := compactor.Write(dir, head, head.MinTime(), head.MaxTime()+1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, id.String())
+}
+
+// tocSection returns the offset of the i-th section in the table of contents
+// of the index of the block.
+func tocSection(t *testing.T, dir string, i int) int64 {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc := content[len(content)-indexTOCLen:]
+	return int64(binary.BigEndian.Uint64(toc[i*8:]))
+}
+
+// overwrite writes b at the offset of the file of the block.
+func overwrite(t *testing.T, dir, file string, offset int64, b []byte) {
+	f, err := os.OpenFile(filepath.Join(dir, file), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// truncate cuts the file of the block to size bytes, or to its size minus
+// size bytes when size is negative.
+func truncate(t *testing.T, dir, file string, size int64) {
+	path := filepath.Join(dir, file)
+	if size < 0 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hugeUvarint() []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, 1<<62)
+	return b[:n]
+}
+
+func TestBlockIntact(t *testing.T) {
+	dir := writeBlock(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	if problems := Block(dir); len(problems) != 0 {
+		t.Errorf("intact block has problems: %v", problems)
+	}
+}
+
+func TestBlockCorrupted(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string)
+	}{
+		{
+			name: "truncated chunk segment",
+			corrupt: func(t *testing.T, dir string) {
+				truncate(t, dir, "chunks/000001", -10)
+			},
+		},
+		{
+			name: "huge chunk length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "chunks/000001", chunkSegmentStart, hugeUvarint())
+			},
+		},
+		{
+			name: "flipped chunk byte",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "chunks/000001", chunkSegmentStart+4, []byte{0xff})
+			},
+		},
+		{
+			name: "truncated index",
+			corrupt: func(t *testing.T, dir string) {
+				truncate(t, dir, "index", -30)
+			},
+		},
+		{
+			name: "index shorter than its table of contents",
+			corrupt: func(t *testing.T, dir string) {
+				truncate(t, dir, "index", 20)
+			},
+		},
+		{
+			name: "table of contents checksum",
+			corrupt: func(t *testing.T, dir string) {
+				fi, err := os.Stat(filepath.Join(dir, "index"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				overwrite(t, dir, "index", fi.Size()-indexTOCLen, []byte{0xff})
+			},
+		},
+		{
+			name: "huge symbol table length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", tocSection(t, dir, 0), []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "huge series length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", alignTo(tocSection(t, dir, 1), 16), hugeUvarint())
+			},
+		},
+		{
+			name: "huge label index length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", alignTo(tocSection(t, dir, 2), 4), []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "huge postings length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", alignTo(tocSection(t, dir, 4), 4), []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "huge label index table length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", tocSection(t, dir, 3), []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "huge postings table length",
+			corrupt: func(t *testing.T, dir string) {
+				overwrite(t, dir, "index", tocSection(t, dir, 5), []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "truncated tombstones",
+			corrupt: func(t *testing.T, dir string) {
+				truncate(t, dir, "tombstones", 3)
+			},
+		},
+		{
+			name: "missing meta.json",
+			corrupt: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "meta.json")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := writeBlock(t)
+			defer os.RemoveAll(filepath.Dir(dir))
+
+			c.corrupt(t, dir)
+			if problems := Block(dir); len(problems) == 0 {
+				t.Errorf("no problems found")
+			}
+		})
+	}
+}